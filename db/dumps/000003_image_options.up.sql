@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS options JSONB DEFAULT NULL;
+
+COMMIT;
@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TABLE images DROP COLUMN IF EXISTS error_message;
+
+COMMIT;
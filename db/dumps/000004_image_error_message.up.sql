@@ -0,0 +1,5 @@
+BEGIN;
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS error_message TEXT NOT NULL DEFAULT '';
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+
+ALTER TABLE images DROP CONSTRAINT IF EXISTS images_status_check;
+
+ALTER TABLE images DROP COLUMN IF EXISTS processed_at;
+ALTER TABLE images DROP COLUMN IF EXISTS attempts;
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS processed_at TIMESTAMP DEFAULT NULL;
+
+ALTER TABLE images ADD CONSTRAINT images_status_check
+    CHECK (status IN ('uploaded', 'enqueued', 'processing', 'processed', 'failed', 'deleting'));
+
+COMMIT;
@@ -0,0 +1,10 @@
+BEGIN;
+
+DROP INDEX IF EXISTS idx_images_content_hash;
+
+ALTER TABLE images DROP COLUMN IF EXISTS content_hash;
+ALTER TABLE images DROP COLUMN IF EXISTS original_name;
+
+DROP TABLE IF EXISTS blobs;
+
+COMMIT;
@@ -0,0 +1,16 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS blobs(
+    hash TEXT PRIMARY KEY,
+    path TEXT NOT NULL,
+    size BIGINT NOT NULL DEFAULT 0,
+    ref_count INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS original_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN IF NOT EXISTS content_hash TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_images_content_hash ON images(content_hash);
+
+COMMIT;
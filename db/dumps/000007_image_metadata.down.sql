@@ -0,0 +1,10 @@
+BEGIN;
+
+ALTER TABLE images DROP COLUMN IF EXISTS orientation;
+ALTER TABLE images DROP COLUMN IF EXISTS height;
+ALTER TABLE images DROP COLUMN IF EXISTS width;
+ALTER TABLE images DROP COLUMN IF EXISTS taken_at;
+ALTER TABLE images DROP COLUMN IF EXISTS camera_model;
+ALTER TABLE images DROP COLUMN IF EXISTS camera_make;
+
+COMMIT;
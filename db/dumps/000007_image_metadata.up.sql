@@ -0,0 +1,10 @@
+BEGIN;
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS camera_make TEXT NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN IF NOT EXISTS camera_model TEXT NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN IF NOT EXISTS taken_at TIMESTAMP;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS width INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS height INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS orientation SMALLINT NOT NULL DEFAULT 0;
+
+COMMIT;
@@ -0,0 +1,14 @@
+BEGIN;
+
+DROP INDEX IF EXISTS idx_images_tags;
+DROP INDEX IF EXISTS idx_images_format;
+DROP INDEX IF EXISTS idx_images_owner_created_at_id;
+DROP INDEX IF EXISTS idx_images_status_id;
+DROP INDEX IF EXISTS idx_images_updated_at_id;
+DROP INDEX IF EXISTS idx_images_created_at_id;
+
+ALTER TABLE images DROP COLUMN IF EXISTS format;
+ALTER TABLE images DROP COLUMN IF EXISTS tags;
+ALTER TABLE images DROP COLUMN IF EXISTS owner_id;
+
+COMMIT;
@@ -0,0 +1,24 @@
+BEGIN;
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS owner_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN IF NOT EXISTS tags JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE images ADD COLUMN IF NOT EXISTS format TEXT NOT NULL DEFAULT '';
+
+UPDATE images SET format = CASE lower(substring(original_path FROM '\.([^./]+)$'))
+    WHEN 'jpg' THEN 'jpeg'
+    WHEN 'jpeg' THEN 'jpeg'
+    WHEN 'png' THEN 'png'
+    WHEN 'gif' THEN 'gif'
+    WHEN 'webp' THEN 'webp'
+    ELSE ''
+END
+WHERE format = '';
+
+CREATE INDEX IF NOT EXISTS idx_images_created_at_id ON images(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_images_updated_at_id ON images(updated_at, id);
+CREATE INDEX IF NOT EXISTS idx_images_status_id ON images(status, id);
+CREATE INDEX IF NOT EXISTS idx_images_owner_created_at_id ON images(owner_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_images_format ON images(format);
+CREATE INDEX IF NOT EXISTS idx_images_tags ON images USING GIN (tags jsonb_path_ops);
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP INDEX IF EXISTS idx_images_original_path;
+CREATE INDEX IF NOT EXISTS idx_images_original_path ON images(original_path);
+
+ALTER TABLE images DROP COLUMN IF EXISTS options_hash;
+
+COMMIT;
@@ -0,0 +1,34 @@
+BEGIN;
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS options_hash TEXT
+    GENERATED ALWAYS AS (md5(COALESCE(options::text, ''))) STORED;
+
+-- дубликаты, созданные прежним поиском оригинала по всей таблице: остается одна запись,
+-- предпочтительно обработанная; ссылки на blob удаленных записей снимаются.
+-- Файлы вариантов удаленных записей остаются в хранилище
+WITH ranked AS (
+    SELECT id, row_number() OVER (
+        PARTITION BY original_path, owner_id, options_hash
+        ORDER BY (status = 'processed') DESC, id
+    ) AS rn
+    FROM images
+), removed AS (
+    DELETE FROM images i
+    USING ranked r
+    WHERE i.id = r.id AND r.rn > 1
+    RETURNING i.content_hash
+)
+UPDATE blobs b
+SET ref_count = b.ref_count - d.cnt
+FROM (
+    SELECT content_hash, COUNT(*) AS cnt
+    FROM removed
+    WHERE content_hash <> ''
+    GROUP BY content_hash
+) d
+WHERE b.hash = d.content_hash;
+
+DROP INDEX IF EXISTS idx_images_original_path;
+CREATE UNIQUE INDEX idx_images_original_path ON images(original_path, owner_id, options_hash);
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP TABLE IF EXISTS processed_jobs;
+
+ALTER TABLE images DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE images DROP COLUMN IF EXISTS processing_owner;
+
+COMMIT;
@@ -0,0 +1,15 @@
+BEGIN;
+
+ALTER TABLE images ADD COLUMN IF NOT EXISTS processing_owner TEXT;
+ALTER TABLE images ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS processed_jobs(
+    job_id TEXT PRIMARY KEY,
+    image_id INTEGER NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+    processing_owner TEXT NOT NULL,
+    processed_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_processed_jobs_image_id ON processed_jobs(image_id);
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE job_queue DROP COLUMN IF EXISTS headers;
+ALTER TABLE outbox DROP COLUMN IF EXISTS headers;
+
+COMMIT;
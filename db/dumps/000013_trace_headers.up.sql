@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS headers JSONB NOT NULL DEFAULT '{}'::jsonb;
+ALTER TABLE job_queue ADD COLUMN IF NOT EXISTS headers JSONB NOT NULL DEFAULT '{}'::jsonb;
+
+COMMIT;
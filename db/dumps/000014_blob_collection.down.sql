@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP INDEX IF EXISTS idx_blobs_unreferenced;
+
+DROP INDEX IF EXISTS idx_images_original_path;
+CREATE UNIQUE INDEX idx_images_original_path ON images(original_path, owner_id, options_hash);
+
+COMMIT;
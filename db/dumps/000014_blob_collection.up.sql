@@ -0,0 +1,13 @@
+BEGIN;
+
+-- удаляемая запись не мешает загрузить тот же файл заново: новая загрузка создает новую запись,
+-- обе держат свою ссылку на blob
+DROP INDEX IF EXISTS idx_images_original_path;
+CREATE UNIQUE INDEX idx_images_original_path ON images(original_path, owner_id, options_hash)
+    WHERE status <> 'deleting';
+
+-- blob без ссылок (в том числе оставшиеся после удаления дубликатов в 000009) собирает ReleaseBlob:
+-- файл в хранилище нельзя удалить из миграции, поэтому записи остаются до сборки
+CREATE INDEX IF NOT EXISTS idx_blobs_unreferenced ON blobs(hash) WHERE ref_count <= 0;
+
+COMMIT;
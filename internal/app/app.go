@@ -2,13 +2,34 @@ package app
 
 import (
 	"context"
-	"log"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/Vladimirmoscow84/Image_processor/internal/auth"
 	"github.com/Vladimirmoscow84/Image_processor/internal/handlers"
+	"github.com/Vladimirmoscow84/Image_processor/internal/health"
+	"github.com/Vladimirmoscow84/Image_processor/internal/metrics"
+	"github.com/Vladimirmoscow84/Image_processor/internal/pipeline"
+	queuebroker "github.com/Vladimirmoscow84/Image_processor/internal/queue_broker"
 	"github.com/Vladimirmoscow84/Image_processor/internal/queue_broker/kafka"
+	memoryqueue "github.com/Vladimirmoscow84/Image_processor/internal/queue_broker/memory_queue"
+	postgresqueue "github.com/Vladimirmoscow84/Image_processor/internal/queue_broker/postgres_queue"
 	"github.com/Vladimirmoscow84/Image_processor/internal/service"
+	"github.com/Vladimirmoscow84/Image_processor/internal/storage/blob"
+	derivedcache "github.com/Vladimirmoscow84/Image_processor/internal/storage/derived_cache"
 	filestorage "github.com/Vladimirmoscow84/Image_processor/internal/storage/file_storage"
 	"github.com/Vladimirmoscow84/Image_processor/internal/storage/postgres"
+	s3storage "github.com/Vladimirmoscow84/Image_processor/internal/storage/s3_storage"
+	"github.com/Vladimirmoscow84/Image_processor/internal/tracing"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
 	"github.com/wb-go/wbf/config"
 	"github.com/wb-go/wbf/ginext"
 )
@@ -17,60 +38,333 @@ func Run() {
 	cfg := config.New()
 	err := cfg.LoadEnvFiles(".env")
 	if err != nil {
-		log.Fatalf("[app] error of loading cfg: %v", err)
+		fatal(slog.Default(), "error of loading cfg", logger.Err(err))
 	}
 	cfg.EnableEnv("")
 
+	cfg.SetDefault("LOG_LEVEL", "info")
+	cfg.SetDefault("LOG_FORMAT", logger.FormatText)
+	log, err := logger.New(os.Stdout, logger.Config{
+		Level:  cfg.GetString("LOG_LEVEL"),
+		Format: cfg.GetString("LOG_FORMAT"),
+	})
+	if err != nil {
+		fatal(slog.Default(), "invalid logger config", logger.Err(err))
+	}
+	// стандартный log и библиотеки, пишущие через slog.Default, попадают в тот же вывод
+	slog.SetDefault(log)
+	log = log.With("component", "app")
+
 	databaseURI := cfg.GetString("DATABASE_URI")
 
 	serverAddr := cfg.GetString("SERVER_ADDRESS")
 
+	cfg.SetDefault("STORAGE_BACKEND", "local")
+	storageBackend := cfg.GetString("STORAGE_BACKEND")
 	fileStorageRoot := cfg.GetString("FILE_STORAGE_ROOT")
+	s3Cfg := &s3storage.Config{
+		Endpoint:  cfg.GetString("S3_ENDPOINT"),
+		AccessKey: cfg.GetString("S3_ACCESS_KEY"),
+		SecretKey: cfg.GetString("S3_SECRET_KEY"),
+		Bucket:    cfg.GetString("S3_BUCKET"),
+		Region:    cfg.GetString("S3_REGION"),
+		UseSSL:    cfg.GetBool("S3_USE_SSL"),
+	}
 	waterMarkPath := cfg.GetString("WATERMARK_PATH")
+	pipelineConfig := cfg.GetString("PIPELINE_CONFIG")
+	cfg.SetDefault("METADATA_STRIP", pipeline.MetadataStripAll)
+	metadataStrip := cfg.GetString("METADATA_STRIP")
+
+	cfg.SetDefault("TRANSFORM_CACHE_DIR", "./data/cache")
+	cfg.SetDefault("TRANSFORM_CACHE_BUDGET", 1<<30)
+	cfg.SetDefault("TRANSFORM_WIDTHS", "160,320,640,960,1280,1920")
+	cfg.SetDefault("TRANSFORM_HEIGHTS", "120,240,480,720,1080")
+	cfg.SetDefault("TRANSFORM_QUALITIES", "60,75,80,90")
+	cfg.SetDefault("TRANSFORM_WATERMARK", true)
+	transformCacheDir := cfg.GetString("TRANSFORM_CACHE_DIR")
+	transformCacheBudget := cfg.GetInt64("TRANSFORM_CACHE_BUDGET")
+	transformCfg := pipeline.TransformConfig{
+		Widths:    parseIntList(cfg.GetString("TRANSFORM_WIDTHS")),
+		Heights:   parseIntList(cfg.GetString("TRANSFORM_HEIGHTS")),
+		Qualities: parseIntList(cfg.GetString("TRANSFORM_QUALITIES")),
+		Watermark: cfg.GetBool("TRANSFORM_WATERMARK"),
+	}
+
+	defaultLimits := service.DefaultUploadLimits()
+	cfg.SetDefault("UPLOAD_MAX_BYTES", defaultLimits.MaxBytes)
+	cfg.SetDefault("UPLOAD_MAX_WIDTH", defaultLimits.MaxWidth)
+	cfg.SetDefault("UPLOAD_MAX_HEIGHT", defaultLimits.MaxHeight)
+	cfg.SetDefault("UPLOAD_MAX_PIXELS", defaultLimits.MaxPixels)
+	uploadLimits := service.UploadLimits{
+		MaxBytes:  cfg.GetInt64("UPLOAD_MAX_BYTES"),
+		MaxWidth:  cfg.GetInt("UPLOAD_MAX_WIDTH"),
+		MaxHeight: cfg.GetInt("UPLOAD_MAX_HEIGHT"),
+		MaxPixels: cfg.GetInt64("UPLOAD_MAX_PIXELS"),
+	}
+
+	cfg.SetDefault("OUTBOX_POLL_INTERVAL", "1s")
+	cfg.SetDefault("OUTBOX_BATCH_SIZE", 100)
+	outboxPollInterval := cfg.GetDuration("OUTBOX_POLL_INTERVAL")
+	outboxBatchSize := cfg.GetInt("OUTBOX_BATCH_SIZE")
+
+	cfg.SetDefault("QUEUE_BACKEND", "kafka")
+	cfg.SetDefault("QUEUE_POLL_INTERVAL", "1s")
+	cfg.SetDefault("QUEUE_VISIBILITY_TIMEOUT", "1m")
+	queueBackend := cfg.GetString("QUEUE_BACKEND")
+	pgQueueCfg := postgresqueue.Config{
+		Workers:      cfg.GetInt("QUEUE_WORKERS"),
+		PollInterval: cfg.GetDuration("QUEUE_POLL_INTERVAL"),
+		Visibility:   cfg.GetDuration("QUEUE_VISIBILITY_TIMEOUT"),
+	}
 
 	kafkaBroker := cfg.GetString("KAFKA_BROKER")
 	kafkaTopic := cfg.GetString("KAFKA_TOPIC")
 	kafkaGroup := cfg.GetString("KAFKA_GROUP")
+	kafkaRetryTopic := cfg.GetString("KAFKA_RETRY_TOPIC")
+	kafkaDeadLetterTopic := cfg.GetString("KAFKA_DLQ_TOPIC")
+	// 0 - по числу CPU и вдвое больше воркеров соответственно
+	kafkaWorkers := cfg.GetInt("KAFKA_WORKERS")
+	kafkaMaxInFlight := cfg.GetInt("KAFKA_MAX_IN_FLIGHT")
+
+	cfg.SetDefault("JOB_MAX_ATTEMPTS", 5)
+	cfg.SetDefault("JOB_RETRY_BASE_DELAY", "1s")
+	cfg.SetDefault("JOB_RETRY_MAX_DELAY", "1m")
+	retryPolicy := service.RetryPolicy{
+		MaxAttempts: cfg.GetInt("JOB_MAX_ATTEMPTS"),
+		BaseDelay:   cfg.GetDuration("JOB_RETRY_BASE_DELAY"),
+		MaxDelay:    cfg.GetDuration("JOB_RETRY_MAX_DELAY"),
+	}
+	cfg.SetDefault("JOB_LEASE_TTL", service.DefaultLeaseTTL.String())
+	jobLeaseTTL := cfg.GetDuration("JOB_LEASE_TTL")
+
+	cfg.SetDefault("TRACING_EXPORTER", tracing.ExporterNone)
+	cfg.SetDefault("TRACING_SAMPLE_RATIO", 1.0)
+	cfg.SetDefault("TRACING_SERVICE_NAME", "image_processor")
+	tracingCfg := tracing.Config{
+		Exporter:    cfg.GetString("TRACING_EXPORTER"),
+		Endpoint:    cfg.GetString("TRACING_ENDPOINT"),
+		Insecure:    cfg.GetBool("TRACING_INSECURE"),
+		SampleRatio: cfg.GetFloat64("TRACING_SAMPLE_RATIO"),
+		ServiceName: cfg.GetString("TRACING_SERVICE_NAME"),
+	}
+
+	cfg.SetDefault("AUTH_ENABLED", true)
+	authCfg := auth.Config{
+		Disabled:         !cfg.GetBool("AUTH_ENABLED"),
+		APIKeys:          cfg.GetString("AUTH_API_KEYS"),
+		JWTSecret:        cfg.GetString("AUTH_JWT_SECRET"),
+		JWTPublicKeyFile: cfg.GetString("AUTH_JWT_PUBLIC_KEY_FILE"),
+		JWTIssuer:        cfg.GetString("AUTH_JWT_ISSUER"),
+		JWTAudience:      cfg.GetString("AUTH_JWT_AUDIENCE"),
+	}
+
+	cfg.SetDefault("HEALTH_CHECK_TIMEOUT", health.DefaultTimeout.String())
+	healthCheckTimeout := cfg.GetDuration("HEALTH_CHECK_TIMEOUT")
+
+	cfg.SetDefault("SHUTDOWN_TIMEOUT", "30s")
+	shutdownTimeout := cfg.GetDuration("SHUTDOWN_TIMEOUT")
+	// пауза между переходом /readyz в 503 и остановкой HTTP-сервера: балансировщик успевает
+	// заметить неготовность и перестать направлять запросы
+	cfg.SetDefault("SHUTDOWN_DRAIN_DELAY", "5s")
+	shutdownDrainDelay := cfg.GetDuration("SHUTDOWN_DRAIN_DELAY")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	authenticator, err := auth.New(authCfg)
+	if err != nil {
+		fatal(log, "invalid auth config", logger.Err(err))
+	}
+	if authCfg.Disabled {
+		log.Warn("authentication is disabled, all requests have admin access")
+	}
+
+	shutdownTracing, err := tracing.Setup(ctx, tracingCfg)
+	if err != nil {
+		fatal(log, "failed to set up tracing", logger.Err(err))
+	}
+
+	postgresStore, err := postgres.New(databaseURI, log)
+	if err != nil {
+		fatal(log, "failed to connect to PG DB", logger.Err(err))
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	err = metrics.RegisterImageStatusCounter(postgresStore.CountImagesByStatus, log)
+	if err != nil {
+		fatal(log, "failed to register metrics", logger.Err(err))
+	}
 
-	postgresStore, err := postgres.New(databaseURI)
+	var blobStore blob.Store
+	switch storageBackend {
+	case "local":
+		blobStore, err = filestorage.New(fileStorageRoot, log)
+	case "s3":
+		blobStore, err = s3storage.New(ctx, s3Cfg, log)
+	default:
+		fatal(log, "unknown storage backend", "backend", storageBackend)
+	}
 	if err != nil {
-		log.Fatalf("[app]failed to connect to PG DB: %v", err)
+		fatal(log, "failed to open storage", "backend", storageBackend, logger.Err(err))
 	}
 
-	fileStorage, err := filestorage.New(fileStorageRoot, waterMarkPath)
+	derivedCache, err := derivedcache.New(transformCacheDir, transformCacheBudget, log)
 	if err != nil {
-		log.Fatalf("[app] failed to open file storage: %v", err)
+		fatal(log, "failed to open transform cache", logger.Err(err))
 	}
 
-	kafkaCfg := &kafka.Config{
-		Brokers: []string{kafkaBroker},
-		Topic:   kafkaTopic,
-		GroupID: kafkaGroup,
+	imagePipeline := pipeline.Default()
+	if pipelineConfig != "" {
+		imagePipeline, err = pipeline.Load(pipelineConfig)
+		if err != nil {
+			fatal(log, "failed to load pipeline", logger.Err(err))
+		}
+	}
+	imagePipeline.SetWatermark(pipeline.LoadWatermark(waterMarkPath, log))
+	err = imagePipeline.SetMetadataPolicy(metadataStrip)
+	if err != nil {
+		fatal(log, "invalid metadata policy", logger.Err(err))
 	}
+	log.Info("pipeline loaded", "variants", len(imagePipeline.Variants))
 
-	kafkaClient, err := kafka.NewKafkaClient(kafkaCfg)
+	var jobQueue queuebroker.Queue
+	var kafkaClient *kafka.Client
+	switch queueBackend {
+	case "kafka":
+		kafkaClient, err = kafka.NewKafkaClient(&kafka.Config{
+			Brokers:         []string{kafkaBroker},
+			Topic:           kafkaTopic,
+			RetryTopic:      kafkaRetryTopic,
+			DeadLetterTopic: kafkaDeadLetterTopic,
+			GroupID:         kafkaGroup,
+			Workers:         kafkaWorkers,
+			MaxInFlight:     kafkaMaxInFlight,
+			Logger:          log,
+		})
+		jobQueue = kafkaClient
+	case "postgres":
+		pgQueueCfg.Logger = log
+		jobQueue = postgresqueue.New(postgresStore.DB, pgQueueCfg)
+	case "memory":
+		jobQueue = memoryqueue.New(pgQueueCfg.Workers, log)
+	default:
+		fatal(log, "unknown queue backend", "backend", queueBackend)
+	}
 	if err != nil {
-		log.Fatalf("[app] failed to init kafka client: %v", err)
+		fatal(log, "failed to init queue", "backend", queueBackend, logger.Err(err))
 	}
+	log.Info("job queue ready", "backend", queueBackend)
 
-	imageService, err := service.New(postgresStore, fileStorage, kafkaClient)
+	imageService, err := service.New(postgresStore, blobStore, jobQueue, imagePipeline, retryPolicy, derivedCache, transformCfg, uploadLimits, jobLeaseTTL, log)
 	if err != nil {
-		log.Fatalf("[app] service init error: %v", err)
+		fatal(log, "service init error", logger.Err(err))
 	}
 
-	go imageService.StartKafkaConsumer(ctx)
+	// очередь читается в своем контексте: при завершении она останавливается после HTTP-сервера
+	consumeCtx, cancelConsume := context.WithCancel(context.Background())
+	defer cancelConsume()
+	imageService.StartConsumer(consumeCtx)
+	relayDone := imageService.StartOutboxRelay(consumeCtx, outboxPollInterval, outboxBatchSize)
+
+	// очереди в PostgreSQL и в памяти отдельной проверки не требуют: первая работает через то же
+	// подключение, вторая всегда доступна
+	readiness := health.New(imageService.Draining,
+		health.Check{Name: "postgres", Timeout: healthCheckTimeout, Run: postgresStore.Ping},
+	)
+	if checker, ok := blobStore.(blob.WritableChecker); ok {
+		readiness.Add(health.Check{Name: "storage", Timeout: healthCheckTimeout, Run: checker.CheckWritable})
+	}
+	if kafkaClient != nil {
+		readiness.Add(health.Check{Name: "kafka_brokers", Timeout: healthCheckTimeout, Run: kafkaClient.CheckBrokers})
+		readiness.Add(health.Check{Name: "kafka_consumer_group", Timeout: healthCheckTimeout, Run: kafkaClient.CheckJoined})
+	}
 
 	engine := ginext.New("release")
-	router := handlers.New(engine, imageService, imageService, imageService, imageService)
+	router := handlers.New(engine, imageService, imageService, imageService, imageService, imageService, imageService, readiness, authenticator, log)
 	router.Routes()
 
-	log.Printf("[app] server started on %s", serverAddr)
-	err = engine.Run(serverAddr)
+	server := &http.Server{
+		Addr:    serverAddr,
+		Handler: engine,
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Info("server started", "addr", serverAddr)
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+		log.Info("shutdown signal received")
+	case err = <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Error("server failed", logger.Err(err))
+		}
+	}
+	// повторный сигнал завершает процесс сразу
+	stop()
+
+	imageService.StopAccepting()
+	if shutdownDrainDelay > 0 {
+		log.Info("waiting for load balancer to stop routing requests", "delay", shutdownDrainDelay)
+		time.Sleep(shutdownDrainDelay)
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Error("failed to drain HTTP server", logger.Err(err))
+	}
+
+	cancelConsume()
+	imageService.WaitJobs(shutdownCtx)
+	// ретранслятор outbox обращается к базе и очереди, поэтому они закрываются после его остановки
+	select {
+	case <-relayDone:
+	case <-shutdownCtx.Done():
+		log.Error("outbox relay did not stop in time")
+	}
+
+	err = jobQueue.Close()
+	if err != nil {
+		log.Error("failed to close queue", "backend", queueBackend, logger.Err(err))
+	}
+	err = postgresStore.Close()
 	if err != nil {
-		log.Fatalf("[app] server failed: %v", err)
+		log.Error("failed to close postgres", logger.Err(err))
 	}
+	if closer, ok := blobStore.(io.Closer); ok {
+		err = closer.Close()
+		if err != nil {
+			log.Error("failed to close storage", logger.Err(err))
+		}
+	}
+	err = shutdownTracing(shutdownCtx)
+	if err != nil {
+		log.Error("failed to flush traces", logger.Err(err))
+	}
+	log.Info("stopped")
+}
+
+// parseIntList разбирает список чисел через запятую, некорректные элементы пропускаются
+func parseIntList(s string) []int {
+	var out []int
+	for _, part := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			if strings.TrimSpace(part) != "" {
+				slog.Warn("skipping invalid list value", "value", part)
+			}
+			continue
+		}
+		out = append(out, n)
+	}
+	return out
+}
 
+// fatal пишет ошибку запуска в лог и завершает процесс
+func fatal(log *slog.Logger, msg string, args ...any) {
+	log.Error(msg, args...)
+	os.Exit(1)
 }
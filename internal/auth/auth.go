@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Роли пользователей
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// APIKeyHeader - заголовок со статическим API-ключом
+const APIKeyHeader = "X-API-Key"
+
+// ErrInvalidCredentials - ключ или токен не прошел проверку
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Principal - аутентифицированный пользователь
+type Principal struct {
+	Subject string
+	Role    string
+}
+
+// IsAdmin сообщает, что пользователь видит изображения всех владельцев
+func (p *Principal) IsAdmin() bool {
+	return p.Role == RoleAdmin
+}
+
+// Scope возвращает область видимости изображений пользователя
+func (p *Principal) Scope() model.OwnerScope {
+	if p.IsAdmin() {
+		return model.AllOwners
+	}
+	return model.OwnerScope{OwnerID: p.Subject}
+}
+
+type ctxKey struct{}
+
+// WithPrincipal сохраняет пользователя в контексте
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, ctxKey{}, p)
+}
+
+// FromContext возвращает пользователя из контекста
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(ctxKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// Config - параметры аутентификации
+type Config struct {
+	// Disabled - аутентификация выключена: все запросы выполняются анонимным администратором
+	Disabled bool
+	// APIKeys - статические ключи в формате "ключ:пользователь[:роль]" через запятую
+	APIKeys string
+	// JWTSecret - секрет для токенов HS256
+	JWTSecret string
+	// JWTPublicKeyFile - PEM-файл открытого ключа для токенов RS256
+	JWTPublicKeyFile string
+	// JWTIssuer и JWTAudience - ожидаемые iss и aud токена; пусто - не проверяются
+	JWTIssuer   string
+	JWTAudience string
+}
+
+// Authenticator проверяет API-ключи и JWT. Ключи хранятся в виде SHA-256,
+// роль в токене берется из claim role или списка roles
+type Authenticator struct {
+	disabled  bool
+	apiKeys   map[[sha256.Size]byte]*Principal
+	secret    []byte
+	publicKey *rsa.PublicKey
+	parser    *jwt.Parser
+}
+
+// claims - поля JWT, которые использует сервис
+type claims struct {
+	Role  string   `json:"role,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// New - конструктор аутентификатора; без отключения нужен хотя бы один способ входа
+func New(cfg Config) (*Authenticator, error) {
+	if cfg.Disabled {
+		return &Authenticator{disabled: true}, nil
+	}
+
+	a := &Authenticator{secret: []byte(cfg.JWTSecret)}
+	var err error
+	a.apiKeys, err = parseAPIKeys(cfg.APIKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	var methods []string
+	if len(a.secret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if cfg.JWTPublicKeyFile != "" {
+		pem, err := os.ReadFile(cfg.JWTPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("[auth] failed to read public key: %w", err)
+		}
+		a.publicKey, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			return nil, fmt.Errorf("[auth] invalid public key: %w", err)
+		}
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	if len(a.apiKeys) == 0 && len(methods) == 0 {
+		return nil, errors.New("[auth] no API keys or JWT keys configured")
+	}
+
+	if len(methods) == 0 {
+		return a, nil
+	}
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if cfg.JWTIssuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.JWTIssuer))
+	}
+	if cfg.JWTAudience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.JWTAudience))
+	}
+	a.parser = jwt.NewParser(opts...)
+	return a, nil
+}
+
+// Authenticate определяет пользователя по заголовку X-API-Key или Authorization: Bearer <JWT>.
+// Без учетных данных возвращается model.ErrUnauthenticated, при неверных - ErrInvalidCredentials
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if a.disabled {
+		return &Principal{Role: RoleAdmin}, nil
+	}
+
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		p, ok := a.apiKeys[sha256.Sum256([]byte(key))]
+		if !ok {
+			return nil, fmt.Errorf("[auth] %w: unknown API key", ErrInvalidCredentials)
+		}
+		return p, nil
+	}
+
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, model.ErrUnauthenticated
+	}
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, fmt.Errorf("[auth] %w: expected Bearer token", ErrInvalidCredentials)
+	}
+	return a.parseToken(strings.TrimSpace(token))
+}
+
+func (a *Authenticator) parseToken(token string) (*Principal, error) {
+	if a.parser == nil {
+		return nil, fmt.Errorf("[auth] %w: JWT is not configured", ErrInvalidCredentials)
+	}
+
+	var c claims
+	_, err := a.parser.ParseWithClaims(token, &c, func(t *jwt.Token) (any, error) {
+		switch t.Method.(type) {
+		case *jwt.SigningMethodHMAC:
+			return a.secret, nil
+		case *jwt.SigningMethodRSA:
+			return a.publicKey, nil
+		}
+		return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[auth] %w: %v", ErrInvalidCredentials, err)
+	}
+	if c.Subject == "" {
+		return nil, fmt.Errorf("[auth] %w: token has no subject", ErrInvalidCredentials)
+	}
+
+	p := &Principal{Subject: c.Subject, Role: RoleUser}
+	if c.Role == RoleAdmin || slices.Contains(c.Roles, RoleAdmin) {
+		p.Role = RoleAdmin
+	}
+	return p, nil
+}
+
+// parseAPIKeys разбирает список "ключ:пользователь[:роль]" через запятую
+func parseAPIKeys(s string) (map[[sha256.Size]byte]*Principal, error) {
+	keys := make(map[[sha256.Size]byte]*Principal)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.New("[auth] API key must be in format key:subject[:role]")
+		}
+		p := &Principal{Subject: parts[1], Role: RoleUser}
+		if len(parts) == 3 {
+			switch parts[2] {
+			case RoleUser, RoleAdmin:
+				p.Role = parts[2]
+			default:
+				return nil, fmt.Errorf("[auth] unknown role %q for API key of %s", parts[2], parts[1])
+			}
+		}
+		keys[sha256.Sum256([]byte(parts[0]))] = p
+	}
+	return keys, nil
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// livenessHandler сообщает, что процесс жив и обслуживает HTTP; зависимости не проверяются
+func (r *Router) livenessHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readinessHandler проверяет зависимости сервиса и возвращает результат по каждой;
+// 503 - хотя бы одна проверка не прошла или сервис завершает работу
+func (r *Router) readinessHandler(c *gin.Context) {
+	report := r.readiness.Ready(c.Request.Context())
+	status := http.StatusOK
+	if !report.OK() {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
 	"github.com/gin-gonic/gin"
 )
 
@@ -21,6 +23,10 @@ func (r *Router) imageDeleterHandler(c *gin.Context) {
 	}
 
 	err = r.imageDeleter.DeleteImage(c.Request.Context(), image)
+	if errors.Is(err, model.ErrStatusConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -1,12 +1,24 @@
 package handlers
 
 import (
+	"errors"
+	"io"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/Vladimirmoscow84/Image_processor/internal/pipeline"
+	"github.com/Vladimirmoscow84/Image_processor/internal/storage/blob"
 	"github.com/gin-gonic/gin"
 )
 
+// изображения доступны только владельцу, поэтому общие кэши их не сохраняют
+const imageCacheControl = "private, max-age=86400"
+
+// imageGetterHandler отдает байты изображения; вариант выбирается параметром ?variant=,
+// по умолчанию processed. Range, If-None-Match и If-Modified-Since обрабатывает http.ServeContent
 func (r *Router) imageGetterHandler(c *gin.Context) {
 
 	idStr := c.Param("id")
@@ -15,10 +27,57 @@ func (r *Router) imageGetterHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter in command line"})
 		return
 	}
-	image, err := r.imageGetter.GetImage(c.Request.Context(), id)
-	if err != nil {
+
+	variant := c.DefaultQuery("variant", pipeline.VariantProcessed)
+	file, info, err := r.imageFileGetter.OpenImageFile(c.Request.Context(), id, variant, acceptedFormats(c.GetHeader("Accept")))
+	if errors.Is(err, model.ErrImageNotFound) || errors.Is(err, blob.ErrNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, image)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	serveImage(c, file, info)
+}
+
+// serveImage отдает файл изображения с заголовками кэширования
+func serveImage(c *gin.Context, file io.ReadSeeker, info *blob.ObjectInfo) {
+	h := c.Writer.Header()
+	if info.ContentType != "" {
+		h.Set("Content-Type", info.ContentType)
+	}
+	if info.ETag != "" {
+		h.Set("ETag", `"`+strings.Trim(info.ETag, `"`)+`"`)
+	}
+	h.Set("Cache-Control", imageCacheControl)
+	h.Set("Vary", "Accept, Authorization, X-API-Key")
+
+	http.ServeContent(c.Writer, c.Request, "", info.ModTime, file)
+}
+
+// acceptedFormats возвращает расширения форматов, явно перечисленных клиентом в заголовке Accept;
+// маски вида image/* не учитываются, иначе любой клиент получал бы перекодированный файл
+func acceptedFormats(header string) []string {
+	if header == "" {
+		return nil
+	}
+	var out []string
+	for _, ext := range pipeline.Negotiable() {
+		contentType := pipeline.ContentType(ext)
+		for _, part := range strings.Split(header, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil || mediaType != contentType {
+				continue
+			}
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q <= 0 {
+				continue
+			}
+			out = append(out, ext)
+			break
+		}
+	}
+	return out
 }
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/gin-gonic/gin"
+)
+
+func (r *Router) imageMetaHandler(c *gin.Context) {
+
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter in command line"})
+		return
+	}
+	image, err := r.imageGetter.GetImage(c.Request.Context(), id)
+	if errors.Is(err, model.ErrImageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, image)
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/Vladimirmoscow84/Image_processor/internal/pipeline"
+	"github.com/Vladimirmoscow84/Image_processor/internal/storage/blob"
+	"github.com/gin-gonic/gin"
+)
+
+// imageTransformHandler отдает изображение произвольного размера из списка допустимых:
+// /image/:id/transform?w=640&h=480&fit=cover&fmt=jpeg&q=80
+func (r *Router) imageTransformHandler(c *gin.Context) {
+
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id parameter in command line"})
+		return
+	}
+
+	t := pipeline.Transform{
+		Fit:    c.Query("fit"),
+		Format: c.Query("fmt"),
+	}
+	for name, dst := range map[string]*int{"w": &t.Width, "h": &t.Height, "q": &t.Quality} {
+		val := c.Query(name)
+		if val == "" {
+			continue
+		}
+		*dst, err = strconv.Atoi(val)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + name + " parameter"})
+			return
+		}
+	}
+
+	file, info, err := r.imageTransformer.TransformImage(c.Request.Context(), id, t, acceptedFormats(c.GetHeader("Accept")))
+	if errors.Is(err, pipeline.ErrInvalidTransform) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, model.ErrImageNotFound) || errors.Is(err, blob.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	serveImage(c, file, info)
+}
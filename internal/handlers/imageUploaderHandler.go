@@ -1,54 +1,192 @@
 package handlers
 
 import (
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	maxTags   = 20
+	maxTagLen = 64
+)
+
+// multipartOverhead - запас на границы и остальные поля формы сверх размера файла
+const multipartOverhead = 1 << 20
+
 func (r *Router) imageUploaderHandler(c *gin.Context) {
+	if r.imageUploader.Draining() {
+		c.Header("Retry-After", "5")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": model.ErrShuttingDown.Error()})
+		return
+	}
+	if limit := r.imageUploader.MaxUploadBytes(); limit > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+multipartOverhead)
+	}
+
 	file, err := c.FormFile("image")
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": model.ErrFileTooLarge.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
 		return
 	}
+	if limit := r.imageUploader.MaxUploadBytes(); limit > 0 && file.Size > limit {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": model.ErrFileTooLarge.Error()})
+		return
+	}
 
-	log.Println("UPLOAD: received file:", file.Filename)
-
-	uploadDir := "data/uploads"
-	os.MkdirAll(uploadDir, 0755)
-
-	origPath := filepath.Join(uploadDir, file.Filename)
-
-	log.Println("UPLOAD: saving to:", origPath)
+	r.log.InfoContext(c.Request.Context(), "upload received", "file", file.Filename, "bytes", file.Size)
 
-	err = c.SaveUploadedFile(file, origPath)
+	opts, err := parseProcessingOptions(c)
 	if err != nil {
-		log.Println("UPLOAD: SaveUploadedFile error:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = r.imageUploader.ValidateOptions(opts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tags, err := parseTags(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	log.Println("UPLOAD: saved OK")
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read file"})
+		return
+	}
+	defer src.Close()
 
 	imgModel := &model.Image{
-		OriginalPath: origPath,
-		Status:       "enqueued",
+		OriginalName: file.Filename,
+		Status:       model.StatusUploaded,
+		Options:      opts,
+		Tags:         tags,
 	}
 
-	id, err := r.imageUploader.AddImage(c.Request.Context(), imgModel)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	id, err := r.imageUploader.UploadImage(c.Request.Context(), imgModel, src)
+	switch {
+	case errors.Is(err, model.ErrFileTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, model.ErrUnsupportedMediaType):
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, model.ErrInvalidImage):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, model.ErrShuttingDown):
+		c.Header("Retry-After", "5")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
 		return
 	}
-	err = r.imageUploader.EnqueueImage(c.Request.Context(), id)
 	if err != nil {
+		r.log.ErrorContext(c.Request.Context(), "failed to store upload", logger.Err(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"status": "enqueued", "id": id})
+
+	r.log.InfoContext(logger.WithImageID(c.Request.Context(), id), "upload saved", "key", imgModel.OriginalPath)
+
+	// новое изображение уже поставлено в очередь; повторная загрузка возвращает существующее,
+	// и в очередь снова ставится только не обработанное
+	if imgModel.Status == model.StatusUploaded || imgModel.Status == model.StatusFailed {
+		err = r.imageUploader.EnqueueImage(c.Request.Context(), id, imgModel.Options)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		imgModel.Status = model.StatusEnqueued
+	}
+	c.JSON(http.StatusOK, gin.H{"status": imgModel.Status, "id": id})
+}
+
+// parseProcessingOptions читает параметры обработки из полей формы, nil - если ничего не передано
+func parseProcessingOptions(c *gin.Context) (*model.ProcessingOptions, error) {
+	opts := &model.ProcessingOptions{
+		Fit:    strings.ToLower(c.PostForm("fit")),
+		Format: strings.ToLower(c.PostForm("format")),
+	}
+	set := opts.Fit != "" || opts.Format != ""
+
+	ints := []struct {
+		field string
+		dest  *int
+	}{
+		{"width", &opts.Width},
+		{"height", &opts.Height},
+		{"quality", &opts.Quality},
+	}
+	for _, f := range ints {
+		v := c.PostForm(f.field)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s parameter", f.field)
+		}
+		*f.dest = n
+		set = true
+	}
+
+	if v := c.PostForm("watermark"); v != "" {
+		wm, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid watermark parameter")
+		}
+		opts.Watermark = &wm
+		set = true
+	}
+
+	for _, v := range c.PostFormArray("variants") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name != "" {
+				opts.Variants = append(opts.Variants, name)
+				set = true
+			}
+		}
+	}
+
+	if !set {
+		return nil, nil
+	}
+	return opts, nil
+}
+
+// parseTags читает метки из поля формы tags (через запятую), приводя их к нижнему регистру
+func parseTags(c *gin.Context) (model.Tags, error) {
+	var tags model.Tags
+	seen := map[string]bool{}
+	for _, v := range c.PostFormArray("tags") {
+		for _, tag := range strings.Split(v, ",") {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag == "" || seen[tag] {
+				continue
+			}
+			if len(tag) > maxTagLen {
+				return nil, fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLen)
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("too many tags, limit is %d", maxTags)
+	}
+	return tags, nil
 }
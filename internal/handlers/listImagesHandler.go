@@ -1,26 +1,92 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
 	"github.com/gin-gonic/gin"
 )
 
+// listImagesHandler отдает страницу списка изображений:
+// /images?limit=50&cursor=...&status=processed,failed&created_from=...&created_to=...&owner=...&tag=...&format=jpeg&sort=-created_at
 func (r *Router) listImagesHandler(c *gin.Context) {
-	images, err := r.listImageGetter.GetAllImages(c.Request.Context())
+	q, err := parseImageQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := r.listImageGetter.ListImages(c.Request.Context(), q)
+	if errors.Is(err, model.ErrInvalidQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	result := []gin.H{}
-	for _, img := range images {
-		result = append(result, gin.H{
+	items := []gin.H{}
+	for _, img := range page.Items {
+		items = append(items, gin.H{
 			"id":            img.ID,
 			"status":        img.Status,
 			"thumbnailPath": img.ThumbnailPath,
+			"errorMessage":  img.ErrorMessage,
+			"originalName":  img.OriginalName,
+			"format":        img.Format,
+			"tags":          img.Tags,
+			"createdAt":     img.CreatedAt,
+			"ownerId":       img.OwnerID,
 		})
 	}
 
-	c.JSON(http.StatusOK, result)
+	c.Header("X-Total-Count", strconv.Itoa(page.Total))
+	c.JSON(http.StatusOK, gin.H{"items": items, "next_cursor": page.NextCursor})
+}
+
+// parseImageQuery читает фильтры, сортировку и позицию страницы из параметров запроса
+func parseImageQuery(c *gin.Context) (*model.ImageQuery, error) {
+	q := &model.ImageQuery{
+		OwnerID: c.Query("owner"),
+		Tag:     strings.ToLower(strings.TrimSpace(c.Query("tag"))),
+		Format:  strings.ToLower(c.Query("format")),
+		Sort:    c.Query("sort"),
+		Cursor:  c.Query("cursor"),
+	}
+
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, errors.New("invalid limit parameter")
+		}
+		q.Limit = n
+	}
+
+	for _, v := range c.QueryArray("status") {
+		for _, st := range strings.Split(v, ",") {
+			st = strings.TrimSpace(st)
+			if st != "" {
+				q.Statuses = append(q.Statuses, model.Status(st))
+			}
+		}
+	}
+
+	for name, dst := range map[string]**time.Time{"created_from": &q.CreatedFrom, "created_to": &q.CreatedTo} {
+		v := c.Query(name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, errors.New("invalid " + name + " parameter, RFC 3339 expected")
+		}
+		t = t.UTC()
+		*dst = &t
+	}
+	return q, nil
 }
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/auth"
+	"github.com/Vladimirmoscow84/Image_processor/internal/metrics"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/Vladimirmoscow84/Image_processor/internal/handlers")
+
+// requestIDHeader - заголовок с ID запроса; принимается от клиента и возвращается в ответе
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen - ID запроса длиннее этого заменяется сгенерированным
+const maxRequestIDLen = 128
+
+// requestIDMiddleware сохраняет ID запроса в контексте: все записи лога по запросу,
+// включая обработку задания воркером, содержат один request_id
+func requestIDMiddleware(c *gin.Context) {
+	id := c.GetHeader(requestIDHeader)
+	if id == "" || len(id) > maxRequestIDLen {
+		id = uuid.NewString()
+	}
+	c.Header(requestIDHeader, id)
+	c.Request = c.Request.WithContext(logger.WithRequestID(c.Request.Context(), id))
+	c.Next()
+}
+
+// authMiddleware определяет пользователя по API-ключу или JWT и сохраняет его в контексте запроса;
+// без учетных данных или с неверными запрос отклоняется с 401
+func (r *Router) authMiddleware(c *gin.Context) {
+	principal, err := r.authenticator.Authenticate(c.Request)
+	if err != nil {
+		r.log.WarnContext(c.Request.Context(), "authentication failed", logger.Err(err))
+		c.Header("WWW-Authenticate", `Bearer realm="image_processor"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	c.Request = c.Request.WithContext(auth.WithPrincipal(c.Request.Context(), principal))
+	c.Next()
+}
+
+// accessLogMiddleware пишет в лог каждый обработанный запрос; уровень зависит от кода ответа.
+// Успешные проверки оркестратора пишутся на уровне debug, чтобы не засорять лог
+func (r *Router) accessLogMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	status := c.Writer.Status()
+	level := slog.LevelInfo
+	switch {
+	case status < http.StatusBadRequest && (c.FullPath() == "/healthz" || c.FullPath() == "/readyz"):
+		level = slog.LevelDebug
+	case status >= http.StatusInternalServerError:
+		level = slog.LevelError
+	case status >= http.StatusBadRequest:
+		level = slog.LevelWarn
+	}
+	attrs := []any{
+		"method", c.Request.Method,
+		"path", c.Request.URL.Path,
+		"status", status,
+		"duration", time.Since(start),
+		"bytes", c.Writer.Size(),
+		"client_ip", c.ClientIP(),
+	}
+	if p, ok := auth.FromContext(c.Request.Context()); ok && p.Subject != "" {
+		attrs = append(attrs, "user", p.Subject)
+	}
+	r.log.Log(c.Request.Context(), level, "request", attrs...)
+}
+
+// metricsMiddleware учитывает запросы по шаблону маршрута, чтобы ID в пути не плодили метки
+func metricsMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	metrics.ObserveHTTP(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+}
+
+// tracingMiddleware открывает серверный спан запроса, продолжая трассу из заголовков traceparent
+func tracingMiddleware(c *gin.Context) {
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := tracer.Start(ctx, c.Request.Method+" "+route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(c.Request.Method),
+			semconv.HTTPRoute(route),
+			semconv.URLPath(c.Request.URL.Path),
+		),
+	)
+	defer span.End()
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
@@ -2,53 +2,99 @@ package handlers
 
 import (
 	"context"
+	"io"
+	"log/slog"
+	"net/http"
 
+	"github.com/Vladimirmoscow84/Image_processor/internal/auth"
+	"github.com/Vladimirmoscow84/Image_processor/internal/health"
+	"github.com/Vladimirmoscow84/Image_processor/internal/metrics"
 	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/Vladimirmoscow84/Image_processor/internal/pipeline"
+	"github.com/Vladimirmoscow84/Image_processor/internal/storage/blob"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
 	"github.com/gin-gonic/gin"
 	"github.com/wb-go/wbf/ginext"
 )
 
 type imageUploader interface {
-	AddImage(ctx context.Context, img *model.Image) (int, error)
-	EnqueueImage(ctx context.Context, imageID int) error
+	ValidateOptions(opts *model.ProcessingOptions) error
+	UploadImage(ctx context.Context, img *model.Image, r io.Reader) (int, error)
+	EnqueueImage(ctx context.Context, imageID int, opts *model.ProcessingOptions) error
+	MaxUploadBytes() int64
+	Draining() bool
 }
 
 type imageGetter interface {
 	GetImage(ctx context.Context, id int) (*model.Image, error)
 }
 type listImageGetter interface {
-	GetAllImages(ctx context.Context) ([]*model.Image, error)
+	ListImages(ctx context.Context, q *model.ImageQuery) (*model.ImagePage, error)
+}
+
+type imageFileGetter interface {
+	OpenImageFile(ctx context.Context, id int, variant string, accept []string) (io.ReadSeekCloser, *blob.ObjectInfo, error)
+}
+
+type imageTransformer interface {
+	TransformImage(ctx context.Context, id int, t pipeline.Transform, accept []string) (io.ReadSeekCloser, *blob.ObjectInfo, error)
 }
 
 type imageDeleter interface {
 	DeleteImage(ctx context.Context, image *model.Image) error
 }
 
+type authenticator interface {
+	Authenticate(r *http.Request) (*auth.Principal, error)
+}
+
+type readinessChecker interface {
+	Ready(ctx context.Context) *health.Report
+}
+
 type Router struct {
-	Router          *ginext.Engine
-	imageUploader   imageUploader
-	imageGetter     imageGetter
-	imageDeleter    imageDeleter
-	listImageGetter listImageGetter
+	Router           *ginext.Engine
+	imageUploader    imageUploader
+	imageGetter      imageGetter
+	imageDeleter     imageDeleter
+	listImageGetter  listImageGetter
+	imageFileGetter  imageFileGetter
+	imageTransformer imageTransformer
+	readiness        readinessChecker
+	authenticator    authenticator
+	log              *slog.Logger
 }
 
-func New(router *ginext.Engine, imageUploader imageUploader, imageGetter imageGetter, imageDeleter imageDeleter, listImageGetter listImageGetter) *Router {
+func New(router *ginext.Engine, imageUploader imageUploader, imageGetter imageGetter, imageDeleter imageDeleter, listImageGetter listImageGetter, imageFileGetter imageFileGetter, imageTransformer imageTransformer, readiness readinessChecker, authenticator authenticator, log *slog.Logger) *Router {
 	return &Router{
-		Router:          router,
-		imageUploader:   imageUploader,
-		imageGetter:     imageGetter,
-		imageDeleter:    imageDeleter,
-		listImageGetter: listImageGetter,
+		Router:           router,
+		imageUploader:    imageUploader,
+		imageGetter:      imageGetter,
+		imageDeleter:     imageDeleter,
+		listImageGetter:  listImageGetter,
+		imageFileGetter:  imageFileGetter,
+		imageTransformer: imageTransformer,
+		readiness:        readiness,
+		authenticator:    authenticator,
+		log:              logger.OrDefault(log).With("component", "http"),
 	}
 }
 
 func (r *Router) Routes() {
-	r.Router.POST("/upload", r.imageUploaderHandler)
-	r.Router.GET("/image/:id", r.imageGetterHandler)
-	r.Router.GET("/images", r.listImagesHandler)
-	r.Router.DELETE("/image/:id", r.imageDeleterHandler)
+	r.Router.Use(requestIDMiddleware, metricsMiddleware, tracingMiddleware, r.accessLogMiddleware)
+	r.Router.GET("/metrics", gin.WrapH(metrics.Handler()))
+	r.Router.GET("/healthz", r.livenessHandler)
+	r.Router.GET("/readyz", r.readinessHandler)
+
+	api := r.Router.Group("", r.authMiddleware)
+	api.POST("/upload", r.imageUploaderHandler)
+	api.GET("/image/:id", r.imageGetterHandler)
+	api.GET("/image/:id/meta", r.imageMetaHandler)
+	api.GET("/image/:id/transform", r.imageTransformHandler)
+	api.GET("/images", r.listImagesHandler)
+	api.DELETE("/image/:id", r.imageDeleterHandler)
+
 	r.Router.GET("/", func(c *gin.Context) { c.File("./web/index.html") })
 	r.Router.Static("/static", "./web")
-	r.Router.Static("/data", "./data")
 
 }
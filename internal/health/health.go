@@ -0,0 +1,123 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Статусы проверок
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// DefaultTimeout - время на одну проверку по умолчанию
+const DefaultTimeout = 2 * time.Second
+
+// errShuttingDown - причина неготовности во время корректного завершения
+var errShuttingDown = errors.New("service is shutting down")
+
+// Check - проверка одной зависимости; Run возвращает ошибку, если зависимость недоступна
+type Check struct {
+	Name string
+	// Timeout - время на проверку; 0 - DefaultTimeout
+	Timeout time.Duration
+	Run     func(ctx context.Context) error
+}
+
+// Result - итог одной проверки
+type Result struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// Report - итог проверки готовности: общий статус и результаты по каждой зависимости
+type Report struct {
+	Status string             `json:"status"`
+	Checks map[string]*Result `json:"checks"`
+}
+
+// OK сообщает, что все проверки прошли
+func (r *Report) OK() bool {
+	return r.Status == StatusOK
+}
+
+// Checker выполняет проверки готовности сервиса
+type Checker struct {
+	checks   []Check
+	draining func() bool
+}
+
+// New - конструктор проверки готовности; пока draining возвращает true, сервис не готов
+// независимо от состояния зависимостей
+func New(draining func() bool, checks ...Check) *Checker {
+	return &Checker{checks: checks, draining: draining}
+}
+
+// Add добавляет проверку
+func (c *Checker) Add(check Check) {
+	c.checks = append(c.checks, check)
+}
+
+// Ready параллельно выполняет все проверки, каждую со своим таймаутом
+func (c *Checker) Ready(ctx context.Context) *Report {
+	report := &Report{Status: StatusOK, Checks: make(map[string]*Result, len(c.checks)+1)}
+	if c.draining != nil && c.draining() {
+		report.Status = StatusFail
+		report.Checks["shutdown"] = &Result{Status: StatusFail, Error: errShuttingDown.Error()}
+		return report
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := run(ctx, check)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[check.Name] = res
+			if res.Status != StatusOK {
+				report.Status = StatusFail
+			}
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+// run выполняет проверку с таймаутом; зависшая проверка считается неуспешной по истечении таймаута
+func run(ctx context.Context, check Check) *Result {
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check.Run(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	res := &Result{Status: StatusOK, DurationMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		res.Status = StatusFail
+		res.Error = err.Error()
+	}
+	return res
+}
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "image_processor"
+
+// Итоги попытки выполнения задания для JobFinished
+const (
+	JobSucceeded = "succeeded"
+	JobRetried   = "retried"
+	JobFailed    = "failed"
+)
+
+var registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"method", "route", "code"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	uploadBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upload_bytes",
+		Help:      "Size of accepted uploads.",
+		Buckets:   prometheus.ExponentialBuckets(16<<10, 4, 8),
+	})
+
+	jobsEnqueued = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_enqueued_total",
+		Help:      "Processing jobs written to the outbox.",
+	})
+
+	jobsFinished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_finished_total",
+		Help:      "Processing job attempts by result: succeeded, retried or failed.",
+	}, []string{"result"})
+
+	variantDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "variant_duration_seconds",
+		Help:      "Time to render, encode and store one variant.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"variant"})
+
+	variantBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "variant_bytes",
+		Help:      "Size of encoded variants.",
+		Buckets:   prometheus.ExponentialBuckets(4<<10, 4, 8),
+	}, []string{"variant"})
+
+	consumerLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "kafka_consumer_lag",
+		Help:      "Messages between the partition high water mark and the first unacknowledged offset.",
+	}, []string{"topic", "partition"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration, uploadBytes, jobsEnqueued, jobsFinished,
+		variantDuration, variantBytes, consumerLag,
+	)
+}
+
+// Handler возвращает HTTP-обработчик, отдающий метрики в формате Prometheus
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveHTTP учитывает обработанный HTTP-запрос; route - шаблон маршрута, а не фактический путь
+func ObserveHTTP(method, route string, code int, d time.Duration) {
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(code)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(d.Seconds())
+}
+
+// ObserveUpload учитывает размер принятой загрузки
+func ObserveUpload(bytes int64) {
+	uploadBytes.Observe(float64(bytes))
+}
+
+// JobEnqueued учитывает задание, поставленное в очередь
+func JobEnqueued() {
+	jobsEnqueued.Inc()
+}
+
+// JobFinished учитывает итог попытки выполнения задания
+func JobFinished(result string) {
+	jobsFinished.WithLabelValues(result).Inc()
+}
+
+// ObserveVariant учитывает время построения варианта и размер результата
+func ObserveVariant(variant string, d time.Duration, bytes int) {
+	variantDuration.WithLabelValues(variant).Observe(d.Seconds())
+	variantBytes.WithLabelValues(variant).Observe(float64(bytes))
+}
+
+// SetConsumerLag запоминает отставание потребителя в партиции
+func SetConsumerLag(topic string, partition int32, lag int64) {
+	consumerLag.WithLabelValues(topic, strconv.Itoa(int(partition))).Set(float64(lag))
+}
+
+// DeleteConsumerLag удаляет отставание партиции, которую потребитель больше не читает,
+// чтобы после перебалансировки не оставалось застывших значений
+func DeleteConsumerLag(topic string, partition int32) {
+	consumerLag.DeleteLabelValues(topic, strconv.Itoa(int(partition)))
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// statusQueryTimeout - сколько ждать подсчет изображений при сборе метрик
+const statusQueryTimeout = 5 * time.Second
+
+var imagesDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "images"),
+	"Current number of images by status.",
+	[]string{"status"}, nil,
+)
+
+// statusCollector запрашивает число изображений по статусам при каждом сборе метрик
+type statusCollector struct {
+	count func(ctx context.Context) (map[string]int, error)
+	log   *slog.Logger
+}
+
+func (c *statusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- imagesDesc
+}
+
+func (c *statusCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), statusQueryTimeout)
+	defer cancel()
+
+	counts, err := c.count(ctx)
+	if err != nil {
+		c.log.Error("failed to count images by status", logger.Err(err))
+		ch <- prometheus.NewInvalidMetric(imagesDesc, err)
+		return
+	}
+	for status, n := range counts {
+		ch <- prometheus.MustNewConstMetric(imagesDesc, prometheus.GaugeValue, float64(n), status)
+	}
+}
+
+// RegisterImageStatusCounter регистрирует метрику числа изображений по статусам, которую count
+// вычисляет при каждом запросе /metrics
+func RegisterImageStatusCounter(count func(ctx context.Context) (map[string]int, error), log *slog.Logger) error {
+	return registry.Register(&statusCollector{
+		count: count,
+		log:   logger.OrDefault(log).With("component", "metrics"),
+	})
+}
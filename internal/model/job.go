@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// JobVersion - текущая версия формата задания в очереди
+const JobVersion = 1
+
+// Job - задание на обработку изображения, передаваемое через очередь
+type Job struct {
+	Version    int                `json:"version"`
+	ID         string             `json:"job_id"`
+	ImageID    int                `json:"image_id"`
+	Options    *ProcessingOptions `json:"options,omitempty"`
+	Attempt    int                `json:"attempt"`
+	TraceID    string             `json:"trace_id,omitempty"`
+	RequestID  string             `json:"request_id,omitempty"`
+	EnqueuedAt time.Time          `json:"enqueued_at"`
+	NotBefore  time.Time          `json:"not_before,omitempty"`
+	LastError  string             `json:"last_error,omitempty"`
+}
+
+// Lease - аренда изображения воркером на время обработки задания
+type Lease struct {
+	Owner string
+	JobID string
+	TTL   time.Duration
+}
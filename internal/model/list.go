@@ -0,0 +1,58 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidQuery - некорректные параметры выборки списка изображений
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Tags - метки изображения, хранятся в JSONB-массиве
+type Tags []string
+
+// Value сериализует метки в JSONB
+func (t Tags) Value() (driver.Value, error) {
+	if t == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]string(t))
+}
+
+// Scan читает метки из JSONB
+func (t *Tags) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, (*[]string)(t))
+	case string:
+		return json.Unmarshal([]byte(v), (*[]string)(t))
+	case nil:
+		*t = nil
+		return nil
+	}
+	return fmt.Errorf("[model] unsupported tags type %T", src)
+}
+
+// ImageQuery - фильтры, сортировка и позиция страницы списка изображений.
+// Sort - имя поля (id, created_at, updated_at), с префиксом "-" - по убыванию
+type ImageQuery struct {
+	Statuses    []Status
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	OwnerID     string
+	Tag         string
+	Format      string
+	Sort        string
+	Limit       int
+	Cursor      string
+}
+
+// ImagePage - страница списка изображений; NextCursor пуст на последней странице
+type ImagePage struct {
+	Items      []*Image
+	NextCursor string
+	Total      int
+}
@@ -1,13 +1,72 @@
 package model
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 type Image struct {
-	ID            int       `json:"id" db:"id"`
-	OriginalPath  string    `json:"original_path" db:"original_path"`
-	ProcessedPath string    `json:"processed_path,omitempty" db:"processed_path"`
-	ThumbnailPath string    `json:"thumbnail_path,omitempty" db:"thumbnail_path"`
-	Status        string    `json:"status" db:"status"`
-	CreatedAt     time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at" db:"updated_at"`
+	ID            int                `json:"id" db:"id"`
+	OriginalPath  string             `json:"original_path" db:"original_path"`
+	OriginalName  string             `json:"original_name,omitempty" db:"original_name"`
+	ContentHash   string             `json:"content_hash,omitempty" db:"content_hash"`
+	OwnerID       string             `json:"owner_id,omitempty" db:"owner_id"`
+	Tags          Tags               `json:"tags,omitempty" db:"tags"`
+	Format        string             `json:"format,omitempty" db:"format"`
+	ProcessedPath string             `json:"processed_path,omitempty" db:"processed_path"`
+	ThumbnailPath string             `json:"thumbnail_path,omitempty" db:"thumbnail_path"`
+	Status        Status             `json:"status" db:"status"`
+	ErrorMessage  string             `json:"error_message,omitempty" db:"error_message"`
+	Attempts      int                `json:"attempts" db:"attempts"`
+	ProcessedAt   *time.Time         `json:"processed_at,omitempty" db:"processed_at"`
+	Options       *ProcessingOptions `json:"options,omitempty" db:"options"`
+	CameraMake    string             `json:"camera_make,omitempty" db:"camera_make"`
+	CameraModel   string             `json:"camera_model,omitempty" db:"camera_model"`
+	TakenAt       *time.Time         `json:"taken_at,omitempty" db:"taken_at"`
+	Width         int                `json:"width" db:"width"`
+	Height        int                `json:"height" db:"height"`
+	Orientation   int                `json:"orientation,omitempty" db:"orientation"`
+	CreatedAt     time.Time          `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time          `json:"updated_at" db:"updated_at"`
+	Variants      []*ImageVariant    `json:"variants,omitempty" db:"-"`
+}
+
+type ImageVariant struct {
+	ImageID   int       `json:"-" db:"image_id"`
+	Name      string    `json:"name" db:"name"`
+	Path      string    `json:"path" db:"path"`
+	Width     int       `json:"width" db:"width"`
+	Height    int       `json:"height" db:"height"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// ProcessingOptions - параметры обработки, переданные клиентом при загрузке
+type ProcessingOptions struct {
+	Width     int      `json:"width,omitempty"`
+	Height    int      `json:"height,omitempty"`
+	Fit       string   `json:"fit,omitempty"`
+	Format    string   `json:"format,omitempty"`
+	Quality   int      `json:"quality,omitempty"`
+	Watermark *bool    `json:"watermark,omitempty"`
+	Variants  []string `json:"variants,omitempty"`
+}
+
+// Value сериализует параметры в JSONB
+func (o ProcessingOptions) Value() (driver.Value, error) {
+	return json.Marshal(o)
+}
+
+// Scan читает параметры из JSONB
+func (o *ProcessingOptions) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, o)
+	case string:
+		return json.Unmarshal([]byte(v), o)
+	case nil:
+		return nil
+	}
+	return fmt.Errorf("[model] unsupported options type %T", src)
 }
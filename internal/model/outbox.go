@@ -0,0 +1,41 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// OutboxMessage - сообщение для очереди, записанное в БД в одной транзакции с изменением изображения
+// и отправляемое позже фоновым ретранслятором
+type OutboxMessage struct {
+	ID      int64   `db:"id"`
+	Key     string  `db:"key"`
+	Payload []byte  `db:"payload"`
+	Headers Headers `db:"headers"`
+}
+
+// Headers - заголовки сообщения очереди (контекст трассировки), хранятся в JSONB-объекте
+type Headers map[string]string
+
+// Value сериализует заголовки в JSONB
+func (h Headers) Value() (driver.Value, error) {
+	if h == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(map[string]string(h))
+}
+
+// Scan читает заголовки из JSONB
+func (h *Headers) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, (*map[string]string)(h))
+	case string:
+		return json.Unmarshal([]byte(v), (*map[string]string)(h))
+	case nil:
+		*h = nil
+		return nil
+	}
+	return fmt.Errorf("[model] unsupported headers type %T", src)
+}
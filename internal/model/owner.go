@@ -0,0 +1,20 @@
+package model
+
+import "errors"
+
+// ErrUnauthenticated - запрос выполняется без аутентифицированного пользователя
+var ErrUnauthenticated = errors.New("authentication required")
+
+// OwnerScope - изображения, доступные вызывающему: только владельца OwnerID или, если All, все
+type OwnerScope struct {
+	OwnerID string
+	All     bool
+}
+
+// AllOwners - область без ограничений для воркера и администратора
+var AllOwners = OwnerScope{All: true}
+
+// Allows сообщает, доступно ли изображение владельца ownerID
+func (s OwnerScope) Allows(ownerID string) bool {
+	return s.All || s.OwnerID == ownerID
+}
@@ -0,0 +1,27 @@
+package model
+
+import "errors"
+
+// Status - состояние изображения в жизненном цикле обработки
+type Status string
+
+const (
+	StatusUploaded   Status = "uploaded"
+	StatusEnqueued   Status = "enqueued"
+	StatusProcessing Status = "processing"
+	StatusProcessed  Status = "processed"
+	StatusFailed     Status = "failed"
+	StatusDeleting   Status = "deleting"
+)
+
+// ErrImageNotFound - изображение с указанным ID отсутствует
+var ErrImageNotFound = errors.New("image not found")
+
+// ErrStatusConflict - текущий статус изображения не допускает запрошенный переход
+var ErrStatusConflict = errors.New("image status conflict")
+
+// ErrImageLeased - изображение обрабатывает другой воркер, и его аренда еще не истекла
+var ErrImageLeased = errors.New("image is leased by another worker")
+
+// ErrLeaseLost - аренда изображения истекла и перешла к другому воркеру
+var ErrLeaseLost = errors.New("image lease lost")
@@ -0,0 +1,15 @@
+package model
+
+import "errors"
+
+// ErrFileTooLarge - размер загружаемого файла превышает лимит
+var ErrFileTooLarge = errors.New("file too large")
+
+// ErrUnsupportedMediaType - содержимое файла не является изображением допустимого типа
+var ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+// ErrInvalidImage - изображение не читается или его размеры превышают лимиты
+var ErrInvalidImage = errors.New("invalid image")
+
+// ErrShuttingDown - сервис завершает работу и не принимает новые загрузки
+var ErrShuttingDown = errors.New("service is shutting down")
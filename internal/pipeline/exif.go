@@ -0,0 +1,387 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Политики метаданных в производных изображениях
+const (
+	MetadataStripAll = "all"
+	MetadataStripGPS = "gps"
+)
+
+// EXIF-теги, которые сервис читает и правит
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagDateTimeOriginal = 0x9003
+	tagThumbOffset      = 0x0201
+	tagThumbLength      = 0x0202
+)
+
+const (
+	markerSOI  = 0xD8
+	markerEOI  = 0xD9
+	markerSOS  = 0xDA
+	markerAPP1 = 0xE1
+
+	exifHeader  = "Exif\x00\x00"
+	exifTimeFmt = "2006:01:02 15:04:05"
+)
+
+var errBadEXIF = errors.New("malformed exif")
+
+// размеры значений TIFF по типу поля
+var tiffTypeSize = map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// Metadata - сведения об оригинале, сохраняемые в записи изображения
+type Metadata struct {
+	CameraMake  string
+	CameraModel string
+	TakenAt     *time.Time
+	Width       int
+	Height      int
+	Orientation int
+}
+
+// ReadMetadata читает размеры изображения и, для JPEG, поля EXIF; размеры учитывают ориентацию
+func ReadMetadata(r io.ReadSeeker) (*Metadata, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, fmt.Errorf("[pipeline] failed to read image config: %w", err)
+	}
+	m := &Metadata{Width: cfg.Width, Height: cfg.Height}
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, fmt.Errorf("[pipeline] failed to rewind image: %w", err)
+	}
+	raw, err := ReadEXIF(r)
+	if err != nil || raw == nil {
+		return m, err
+	}
+
+	t, err := parseTIFF(raw)
+	if err != nil {
+		return m, err
+	}
+	ifd0, _, err := t.ifd(t.first)
+	if err != nil {
+		return m, err
+	}
+	for _, e := range ifd0 {
+		switch e.tag {
+		case tagMake:
+			m.CameraMake = t.ascii(e)
+		case tagModel:
+			m.CameraModel = t.ascii(e)
+		case tagOrientation:
+			m.Orientation = int(t.uint(e))
+		case tagExifIFD:
+			sub, _, err := t.ifd(t.uint(e))
+			if err != nil {
+				return m, err
+			}
+			for _, se := range sub {
+				if se.tag != tagDateTimeOriginal {
+					continue
+				}
+				takenAt, err := time.Parse(exifTimeFmt, t.ascii(se))
+				if err == nil {
+					m.TakenAt = &takenAt
+				}
+			}
+		}
+	}
+
+	// ориентации 5-8 поворачивают изображение на 90 градусов
+	if m.Orientation >= 5 && m.Orientation <= 8 {
+		m.Width, m.Height = m.Height, m.Width
+	}
+	return m, nil
+}
+
+// ReadEXIF возвращает TIFF-данные EXIF из сегмента APP1 JPEG либо nil, если их нет
+func ReadEXIF(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	_, err := io.ReadFull(br, soi[:])
+	if err != nil || soi[0] != 0xFF || soi[1] != markerSOI {
+		return nil, nil
+	}
+
+	for {
+		marker, err := nextMarker(br)
+		if err != nil {
+			return nil, nil
+		}
+		if marker == markerSOS || marker == markerEOI {
+			return nil, nil
+		}
+		var l [2]byte
+		_, err = io.ReadFull(br, l[:])
+		if err != nil {
+			return nil, nil
+		}
+		n := int(binary.BigEndian.Uint16(l[:])) - 2
+		if n < 0 {
+			return nil, fmt.Errorf("[pipeline] %w: bad segment length", errBadEXIF)
+		}
+		if marker != markerAPP1 {
+			_, err = br.Discard(n)
+			if err != nil {
+				return nil, nil
+			}
+			continue
+		}
+		seg := make([]byte, n)
+		_, err = io.ReadFull(br, seg)
+		if err != nil {
+			return nil, nil
+		}
+		if strings.HasPrefix(string(seg), exifHeader) {
+			return seg[len(exifHeader):], nil
+		}
+	}
+}
+
+// nextMarker пропускает байты заполнения и возвращает код следующего маркера
+func nextMarker(br *bufio.Reader) (byte, error) {
+	b, err := br.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, errBadEXIF
+	}
+	for b == 0xFF {
+		b, err = br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// OutputEXIF возвращает EXIF для записи в производные изображения согласно политике конвейера
+func (p *Pipeline) OutputEXIF(raw []byte) []byte {
+	if p.metadata != MetadataStripGPS || raw == nil {
+		return nil
+	}
+	out, err := StripGPS(raw)
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+// StripGPS возвращает копию EXIF без GPS-данных и встроенной миниатюры и с ориентацией 1,
+// так как пиксели производных изображений уже повернуты
+func StripGPS(raw []byte) ([]byte, error) {
+	t, err := parseTIFF(bytes.Clone(raw))
+	if err != nil {
+		return nil, err
+	}
+	chain, err := t.chain()
+	if err != nil {
+		return nil, err
+	}
+	ifd0, _, err := t.ifd(t.first)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range ifd0 {
+		switch e.tag {
+		case tagOrientation:
+			if e.typ == 3 && e.count == 1 {
+				t.order.PutUint16(t.data[e.value:], 1)
+			}
+		case tagGPSIFD:
+			gps := t.uint(e)
+			if slices.Contains(chain, gps) {
+				return nil, fmt.Errorf("[pipeline] %w: gps ifd points into ifd chain", errBadEXIF)
+			}
+			err = t.clearIFD(gps)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// каталоги после IFD0 (миниатюра) удаляются целиком
+	for _, off := range chain[1:] {
+		err = t.clearIFD(off)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(chain) > 1 {
+		t.order.PutUint32(t.data[t.first+2+uint32(len(ifd0))*12:], 0)
+	}
+	return t.data, nil
+}
+
+// EmbedEXIF добавляет EXIF в закодированный JPEG сразу после маркера SOI;
+// для других форматов и слишком больших блоков данные возвращаются без изменений
+func EmbedEXIF(data []byte, ext string, raw []byte) []byte {
+	ext = strings.ToLower(ext)
+	if raw == nil || (ext != ".jpg" && ext != ".jpeg") || len(data) < 2 {
+		return data
+	}
+	segLen := 2 + len(exifHeader) + len(raw)
+	if segLen > 0xFFFF {
+		return data
+	}
+
+	out := make([]byte, 0, len(data)+2+segLen)
+	out = append(out, data[:2]...)
+	out = append(out, 0xFF, markerAPP1, byte(segLen>>8), byte(segLen))
+	out = append(out, exifHeader...)
+	out = append(out, raw...)
+	return append(out, data[2:]...)
+}
+
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+	first uint32
+}
+
+// ifdEntry - запись каталога; value - смещение значения в data
+type ifdEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value uint32
+	size  uint32
+}
+
+func parseTIFF(b []byte) (*tiff, error) {
+	if len(b) < 8 {
+		return nil, errBadEXIF
+	}
+	t := &tiff{data: b}
+	switch string(b[:4]) {
+	case "II*\x00":
+		t.order = binary.LittleEndian
+	case "MM\x00*":
+		t.order = binary.BigEndian
+	default:
+		return nil, errBadEXIF
+	}
+	t.first = t.order.Uint32(b[4:])
+	return t, nil
+}
+
+// ifd читает каталог по смещению и возвращает его записи и смещение следующего каталога
+func (t *tiff) ifd(off uint32) ([]ifdEntry, uint32, error) {
+	if uint64(off)+2 > uint64(len(t.data)) {
+		return nil, 0, errBadEXIF
+	}
+	n := uint32(t.order.Uint16(t.data[off:]))
+	end := uint64(off) + 2 + uint64(n)*12
+	if end+4 > uint64(len(t.data)) {
+		return nil, 0, errBadEXIF
+	}
+
+	entries := make([]ifdEntry, 0, n)
+	for i := uint32(0); i < n; i++ {
+		pos := off + 2 + i*12
+		e := ifdEntry{
+			tag:   t.order.Uint16(t.data[pos:]),
+			typ:   t.order.Uint16(t.data[pos+2:]),
+			count: t.order.Uint32(t.data[pos+4:]),
+			value: pos + 8,
+		}
+		size := uint64(tiffTypeSize[e.typ]) * uint64(e.count)
+		if size > 4 {
+			e.value = t.order.Uint32(t.data[pos+8:])
+		}
+		if uint64(e.value)+size > uint64(len(t.data)) {
+			return nil, 0, errBadEXIF
+		}
+		e.size = uint32(size)
+		entries = append(entries, e)
+	}
+	return entries, t.order.Uint32(t.data[end:]), nil
+}
+
+// chain возвращает смещения каталогов цепочки, начиная с IFD0; ссылка на уже пройденный
+// каталог - ошибка, а не бесконечный цикл
+func (t *tiff) chain() ([]uint32, error) {
+	var offs []uint32
+	seen := make(map[uint32]bool)
+	for off := t.first; off != 0; {
+		if seen[off] {
+			return nil, fmt.Errorf("[pipeline] %w: ifd chain cycles at offset %d", errBadEXIF, off)
+		}
+		_, next, err := t.ifd(off)
+		if err != nil {
+			return nil, err
+		}
+		seen[off] = true
+		offs = append(offs, off)
+		off = next
+	}
+	if len(offs) == 0 {
+		return nil, errBadEXIF
+	}
+	return offs, nil
+}
+
+// clearIFD затирает нулями каталог, значения его записей и данные встроенной миниатюры
+func (t *tiff) clearIFD(off uint32) error {
+	entries, _, err := t.ifd(off)
+	if err != nil {
+		return err
+	}
+
+	var thumbOff, thumbLen uint32
+	for _, e := range entries {
+		switch e.tag {
+		case tagThumbOffset:
+			thumbOff = t.uint(e)
+		case tagThumbLength:
+			thumbLen = t.uint(e)
+		}
+		if e.size > 4 {
+			clear(t.data[e.value : e.value+e.size])
+		}
+	}
+	if thumbLen > 0 && uint64(thumbOff)+uint64(thumbLen) <= uint64(len(t.data)) {
+		clear(t.data[thumbOff : thumbOff+thumbLen])
+	}
+
+	clear(t.data[off : off+2+uint32(len(entries))*12+4])
+	return nil
+}
+
+func (t *tiff) ascii(e ifdEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(t.data[e.value:e.value+e.size]), "\x00"))
+}
+
+func (t *tiff) uint(e ifdEntry) uint32 {
+	switch e.typ {
+	case 3:
+		return uint32(t.order.Uint16(t.data[e.value:]))
+	case 4:
+		return t.order.Uint32(t.data[e.value:])
+	}
+	return 0
+}
@@ -0,0 +1,393 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// testEntry - запись каталога для сборки TIFF в тестах; значения длиннее 4 байт кладутся после каталога
+type testEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+// byteOrder - порядок байт, умеющий и читать, и дописывать значения
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// tiffBuilder собирает TIFF-блок EXIF по частям с явными смещениями каталогов
+type tiffBuilder struct {
+	order byteOrder
+	buf   []byte
+}
+
+func newTIFF(order byteOrder) *tiffBuilder {
+	b := &tiffBuilder{order: order, buf: make([]byte, 8)}
+	if order == binary.LittleEndian {
+		copy(b.buf, "II*\x00")
+	} else {
+		copy(b.buf, "MM\x00*")
+	}
+	return b
+}
+
+func (b *tiffBuilder) short(v uint16) []byte {
+	return b.order.AppendUint16(nil, v)
+}
+
+func (b *tiffBuilder) long(v uint32) []byte {
+	return b.order.AppendUint32(nil, v)
+}
+
+func (b *tiffBuilder) ascii(s string) testEntry {
+	return testEntry{typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+}
+
+// ifd добавляет каталог в конец блока и возвращает его смещение
+func (b *tiffBuilder) ifd(entries []testEntry, next uint32) uint32 {
+	off := uint32(len(b.buf))
+	b.buf = b.order.AppendUint16(b.buf, uint16(len(entries)))
+	extra := off + 2 + uint32(len(entries))*12 + 4
+	var values []byte
+	for _, e := range entries {
+		b.buf = b.order.AppendUint16(b.buf, e.tag)
+		b.buf = b.order.AppendUint16(b.buf, e.typ)
+		b.buf = b.order.AppendUint32(b.buf, e.count)
+		if len(e.value) <= 4 {
+			var inline [4]byte
+			copy(inline[:], e.value)
+			b.buf = append(b.buf, inline[:]...)
+			continue
+		}
+		b.buf = b.order.AppendUint32(b.buf, extra+uint32(len(values)))
+		values = append(values, e.value...)
+	}
+	b.buf = b.order.AppendUint32(b.buf, next)
+	b.buf = append(b.buf, values...)
+	return off
+}
+
+// raw добавляет произвольные данные и возвращает их смещение
+func (b *tiffBuilder) raw(data []byte) uint32 {
+	off := uint32(len(b.buf))
+	b.buf = append(b.buf, data...)
+	return off
+}
+
+// link задает ссылку на следующий каталог для каталога по смещению from
+func (b *tiffBuilder) link(from, to uint32) {
+	n := uint32(b.order.Uint16(b.buf[from:]))
+	b.order.PutUint32(b.buf[from+2+n*12:], to)
+}
+
+func (b *tiffBuilder) bytes(first uint32) []byte {
+	b.order.PutUint32(b.buf[4:], first)
+	return bytes.Clone(b.buf)
+}
+
+// testJPEG кодирует JPEG 4x2: по размерам видно, повернуты ли они по ориентации
+func testJPEG(t testing.TB) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 4, 2)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadMetadataOrientation(t *testing.T) {
+	for o := uint16(1); o <= 8; o++ {
+		order := byteOrder(binary.LittleEndian)
+		if o%2 == 0 {
+			order = binary.BigEndian
+		}
+		b := newTIFF(order)
+		ifd0 := b.ifd([]testEntry{{tag: tagOrientation, typ: 3, count: 1, value: b.short(o)}}, 0)
+		data := EmbedEXIF(testJPEG(t), ".jpg", b.bytes(ifd0))
+
+		m, err := ReadMetadata(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("orientation %d: %v", o, err)
+		}
+		wantW, wantH := 4, 2
+		if o >= 5 {
+			wantW, wantH = 2, 4
+		}
+		if m.Orientation != int(o) || m.Width != wantW || m.Height != wantH {
+			t.Errorf("orientation %d: got orientation %d, size %dx%d, want %dx%d",
+				o, m.Orientation, m.Width, m.Height, wantW, wantH)
+		}
+	}
+}
+
+func TestReadMetadataFields(t *testing.T) {
+	b := newTIFF(binary.BigEndian)
+	exif := b.ifd([]testEntry{withTag(tagDateTimeOriginal, b.ascii("2024:05:17 10:30:00"))}, 0)
+	ifd0 := b.ifd([]testEntry{
+		withTag(tagMake, b.ascii("Canon")),
+		withTag(tagModel, b.ascii("EOS R6 ")),
+		{tag: tagExifIFD, typ: 4, count: 1, value: b.long(exif)},
+	}, 0)
+	data := EmbedEXIF(testJPEG(t), ".jpg", b.bytes(ifd0))
+
+	m, err := ReadMetadata(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.CameraMake != "Canon" || m.CameraModel != "EOS R6" || m.Orientation != 0 {
+		t.Fatalf("metadata = %+v", m)
+	}
+	if m.TakenAt == nil || m.TakenAt.Format(exifTimeFmt) != "2024:05:17 10:30:00" {
+		t.Fatalf("taken at = %v", m.TakenAt)
+	}
+
+	// без EXIF - только размеры
+	m, err = ReadMetadata(bytes.NewReader(testJPEG(t)))
+	if err != nil || m.Width != 4 || m.Height != 2 || m.CameraMake != "" {
+		t.Fatalf("metadata without exif = %+v, %v", m, err)
+	}
+}
+
+func withTag(tag uint16, e testEntry) testEntry {
+	e.tag = tag
+	return e
+}
+
+func TestStripGPS(t *testing.T) {
+	for _, order := range []byteOrder{binary.LittleEndian, binary.BigEndian} {
+		b := newTIFF(order)
+		gpsData := []byte("GPSLAT01GPSLAT02GPSLAT03")
+		gps := b.ifd([]testEntry{
+			{tag: 0x0001, typ: 2, count: 2, value: []byte("N\x00")},
+			{tag: 0x0002, typ: 5, count: 3, value: gpsData},
+		}, 0)
+		thumb := []byte("THUMBNAIL-BYTES")
+		thumbOff := b.raw(thumb)
+		ifd1 := b.ifd([]testEntry{
+			{tag: tagThumbOffset, typ: 4, count: 1, value: b.long(thumbOff)},
+			{tag: tagThumbLength, typ: 4, count: 1, value: b.long(uint32(len(thumb)))},
+		}, 0)
+		ifd0 := b.ifd([]testEntry{
+			withTag(tagMake, b.ascii("Nikon")),
+			{tag: tagOrientation, typ: 3, count: 1, value: b.short(6)},
+			{tag: tagGPSIFD, typ: 4, count: 1, value: b.long(gps)},
+		}, ifd1)
+		raw := b.bytes(ifd0)
+		orig := bytes.Clone(raw)
+
+		out, err := StripGPS(raw)
+		if err != nil {
+			t.Fatalf("%s: %v", order, err)
+		}
+		if !bytes.Equal(raw, orig) {
+			t.Fatalf("%s: StripGPS modified its input", order)
+		}
+		if bytes.Contains(out, gpsData) || bytes.Contains(out, thumb) {
+			t.Fatalf("%s: gps data or thumbnail left in output", order)
+		}
+
+		tf, err := parseTIFF(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, next, err := tf.ifd(tf.first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next != 0 {
+			t.Fatalf("%s: ifd1 still linked at %d", order, next)
+		}
+		for _, e := range entries {
+			switch e.tag {
+			case tagOrientation:
+				if v := tf.uint(e); v != 1 {
+					t.Fatalf("%s: orientation = %d, want 1", order, v)
+				}
+			case tagMake:
+				if v := tf.ascii(e); v != "Nikon" {
+					t.Fatalf("%s: make = %q", order, v)
+				}
+			}
+		}
+		gpsEntries, _, err := tf.ifd(gps)
+		if err != nil || len(gpsEntries) != 0 {
+			t.Fatalf("%s: gps ifd not cleared: %d entries, %v", order, len(gpsEntries), err)
+		}
+	}
+}
+
+func TestEmbedEXIFRoundTrip(t *testing.T) {
+	b := newTIFF(binary.LittleEndian)
+	raw := b.bytes(b.ifd([]testEntry{withTag(tagMake, b.ascii("Sony"))}, 0))
+	src := testJPEG(t)
+
+	for _, ext := range []string{".jpg", ".JPEG"} {
+		data := EmbedEXIF(src, ext, raw)
+		got, err := ReadEXIF(bytes.NewReader(data))
+		if err != nil || !bytes.Equal(got, raw) {
+			t.Fatalf("%s: ReadEXIF = %x, %v, want %x", ext, got, err, raw)
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+			t.Fatalf("%s: jpeg with embedded exif does not decode: %v", ext, err)
+		}
+	}
+
+	unchanged := []struct {
+		name string
+		ext  string
+		raw  []byte
+	}{
+		{"png", ".png", raw},
+		{"no exif", ".jpg", nil},
+		{"segment too large", ".jpg", make([]byte, 0x10000)},
+	}
+	for _, tc := range unchanged {
+		if got := EmbedEXIF(src, tc.ext, tc.raw); !bytes.Equal(got, src) {
+			t.Errorf("%s: EmbedEXIF changed data", tc.name)
+		}
+	}
+
+	got, err := ReadEXIF(bytes.NewReader(src))
+	if got != nil || err != nil {
+		t.Fatalf("ReadEXIF without exif = %x, %v", got, err)
+	}
+}
+
+// TestMalformedEXIF проверяет, что поврежденный EXIF дает ошибку, а не панику или зацикливание
+func TestMalformedEXIF(t *testing.T) {
+	le := binary.LittleEndian
+	cases := []struct {
+		name string
+		raw  func() []byte
+		// strip, meta - ошибку должны вернуть StripGPS и ReadMetadata; ReadMetadata читает
+		// только IFD0 и каталог EXIF, StripGPS - цепочку каталогов и каталог GPS
+		strip, meta bool
+	}{
+		{"short header", func() []byte { return []byte("II*\x00") }, true, true},
+		{"bad byte order", func() []byte { return []byte("XX*\x00\x08\x00\x00\x00") }, true, true},
+		{"first ifd out of range", func() []byte {
+			return newTIFF(le).bytes(1000)
+		}, true, true},
+		{"no ifd", func() []byte {
+			return newTIFF(le).bytes(0)
+		}, true, true},
+		{"truncated ifd", func() []byte {
+			b := newTIFF(le)
+			off := b.raw(le.AppendUint16(nil, 5))
+			return b.bytes(off)
+		}, true, true},
+		{"truncated entry value", func() []byte {
+			b := newTIFF(le)
+			off := b.ifd([]testEntry{withTag(tagMake, b.ascii("truncated make"))}, 0)
+			raw := b.bytes(off)
+			return raw[:len(raw)-4]
+		}, true, true},
+		{"value offset out of range", func() []byte {
+			b := newTIFF(le)
+			off := b.ifd([]testEntry{{tag: tagMake, typ: 2, count: 16, value: make([]byte, 16)}}, 0)
+			raw := b.bytes(off)
+			le.PutUint32(raw[off+2+8:], 0xFFFFFFF0)
+			return raw
+		}, true, true},
+		{"count overflow", func() []byte {
+			b := newTIFF(le)
+			off := b.ifd([]testEntry{{tag: tagModel, typ: 12, count: 0xFFFFFFFF, value: make([]byte, 8)}}, 0)
+			return b.bytes(off)
+		}, true, true},
+		{"exif ifd out of range", func() []byte {
+			b := newTIFF(le)
+			return b.bytes(b.ifd([]testEntry{{tag: tagExifIFD, typ: 4, count: 1, value: b.long(0xFFFF)}}, 0))
+		}, false, true},
+		{"next ifd out of range", func() []byte {
+			b := newTIFF(le)
+			return b.bytes(b.ifd(nil, 0xFFFF))
+		}, true, false},
+		{"gps ifd out of range", func() []byte {
+			b := newTIFF(le)
+			return b.bytes(b.ifd([]testEntry{{tag: tagGPSIFD, typ: 4, count: 1, value: b.long(0x7FFFFFFF)}}, 0))
+		}, true, false},
+		{"ifd0 links to itself", func() []byte {
+			b := newTIFF(le)
+			off := b.ifd(nil, 0)
+			b.link(off, off)
+			return b.bytes(off)
+		}, true, false},
+		{"ifd chain cycle", func() []byte {
+			b := newTIFF(le)
+			ifd0 := b.ifd(nil, 0)
+			ifd1 := b.ifd(nil, 0)
+			ifd2 := b.ifd(nil, ifd1)
+			b.link(ifd0, ifd1)
+			b.link(ifd1, ifd2)
+			return b.bytes(ifd0)
+		}, true, false},
+		{"gps ifd points to ifd0", func() []byte {
+			b := newTIFF(le)
+			off := uint32(len(b.buf))
+			b.ifd([]testEntry{{tag: tagGPSIFD, typ: 4, count: 1, value: b.long(off)}}, 0)
+			return b.bytes(off)
+		}, true, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := StripGPS(tc.raw())
+			if tc.strip && !errors.Is(err, errBadEXIF) {
+				t.Fatalf("StripGPS = %v, want errBadEXIF", err)
+			}
+
+			data := EmbedEXIF(testJPEG(t), ".jpg", tc.raw())
+			m, err := ReadMetadata(bytes.NewReader(data))
+			if m == nil || m.Width != 4 || m.Height != 2 {
+				t.Fatalf("ReadMetadata lost image size: %+v", m)
+			}
+			if tc.meta && !errors.Is(err, errBadEXIF) {
+				t.Fatalf("ReadMetadata = %v, want errBadEXIF", err)
+			}
+		})
+	}
+}
+
+func TestReadEXIFBadSegmentLength(t *testing.T) {
+	data := []byte{0xFF, markerSOI, 0xFF, markerAPP1, 0x00, 0x01}
+	_, err := ReadEXIF(bytes.NewReader(data))
+	if !errors.Is(err, errBadEXIF) {
+		t.Fatalf("ReadEXIF = %v, want errBadEXIF", err)
+	}
+}
+
+func FuzzReadEXIF(f *testing.F) {
+	src := testJPEG(f)
+	b := newTIFF(binary.BigEndian)
+	gps := b.ifd([]testEntry{{tag: 0x0002, typ: 5, count: 3, value: make([]byte, 24)}}, 0)
+	ifd1 := b.ifd([]testEntry{{tag: tagThumbOffset, typ: 4, count: 1, value: b.long(8)}}, 0)
+	ifd0 := b.ifd([]testEntry{
+		withTag(tagMake, b.ascii("Canon")),
+		{tag: tagOrientation, typ: 3, count: 1, value: b.short(6)},
+		{tag: tagGPSIFD, typ: 4, count: 1, value: b.long(gps)},
+	}, ifd1)
+	f.Add(EmbedEXIF(src, ".jpg", b.bytes(ifd0)))
+	f.Add(src)
+	f.Add([]byte{0xFF, markerSOI, 0xFF, markerAPP1, 0x00, 0x10})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		raw, err := ReadEXIF(bytes.NewReader(data))
+		if err != nil || raw == nil {
+			return
+		}
+		out, err := StripGPS(raw)
+		if err == nil && len(out) != len(raw) {
+			t.Fatalf("StripGPS changed exif size: %d -> %d", len(raw), len(out))
+		}
+		ReadMetadata(bytes.NewReader(data))
+	})
+}
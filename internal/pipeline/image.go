@@ -0,0 +1,124 @@
+package pipeline
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
+	"github.com/disintegration/imaging"
+	_ "golang.org/x/image/webp"
+)
+
+// LoadWatermark загружает изображение водяного знака; без файла обработка идет без водяного знака
+func LoadWatermark(watermarkPath string, log *slog.Logger) image.Image {
+	log = logger.OrDefault(log).With("component", "pipeline")
+	if watermarkPath == "" {
+		log.Info("no watermark provided")
+		return nil
+	}
+	if _, err := os.Stat(watermarkPath); err != nil {
+		log.Warn("watermark file not found, proceeding without watermark", "path", watermarkPath)
+		return nil
+	}
+	wm, err := imaging.Open(watermarkPath)
+	if err != nil {
+		log.Error("failed to load watermark", "path", watermarkPath, logger.Err(err))
+		return nil
+	}
+	log.Info("watermark loaded", "path", watermarkPath)
+	return wm
+}
+
+// Encode кодирует изображение в формат по расширению ext, по умолчанию JPG
+func Encode(w io.Writer, img image.Image, ext string, quality int) error {
+	var err error
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg":
+		err = jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	case ".png":
+		err = png.Encode(w, img)
+	case ".gif":
+		err = gif.Encode(w, img, nil)
+	case ".webp":
+		// кодировщик без CGO поддерживает только сжатие без потерь, quality не учитывается
+		err = nativewebp.Encode(w, img, nil)
+	default:
+		// по умолчанию JPG
+		err = jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
+		return fmt.Errorf("[pipeline] failed to encode image: %w", err)
+	}
+	return nil
+}
+
+// ContentType возвращает MIME-тип результата Encode для расширения ext
+func ContentType(ext string) string {
+	switch strings.ToLower(ext) {
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".webp":
+		return "image/webp"
+	}
+	return "image/jpeg"
+}
+
+// Negotiable возвращает расширения форматов, которые отдаются вместо сохраненного,
+// если клиент явно принимает их в заголовке Accept. Ограничения: WebP кодируется только
+// без потерь (quality не учитывается), поэтому согласование имеет смысл лишь для PNG и GIF
+// (см. Lossy); AVIF не поддерживается вовсе - кодировщика без CGO нет, и image/avif в Accept
+// игнорируется
+func Negotiable() []string {
+	return []string{".webp"}
+}
+
+// Lossy сообщает, что формат ext сжат с потерями. Такой файл не перекодируется при согласовании:
+// WebP без потерь для фотографий почти всегда больше, а попытка стоит декодирования и кодирования
+func Lossy(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg":
+		return true
+	}
+	return false
+}
+
+// ResizeImage изменяет размер изображения
+func ResizeImage(img image.Image, width, height int) image.Image {
+	return imaging.Resize(img, width, height, imaging.Lanczos)
+}
+
+// CreateThumbnail создает миниатюру
+func CreateThumbnail(img image.Image, width, height int) image.Image {
+	return imaging.Thumbnail(img, width, height, imaging.Lanczos)
+}
+
+// applyWatermark накладывает водяной знак на изображение
+func applyWatermark(base, watermark image.Image) image.Image {
+	result := imaging.Clone(base)
+
+	offset := image.Pt(
+		base.Bounds().Dx()-watermark.Bounds().Dx()-10,
+		base.Bounds().Dy()-watermark.Bounds().Dy()-10,
+	)
+
+	draw.Draw(
+		result,
+		watermark.Bounds().Add(offset),
+		watermark,
+		image.Point{},
+		draw.Over,
+	)
+
+	return result
+}
@@ -0,0 +1,331 @@
+package pipeline
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"strings"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/disintegration/imaging"
+	"github.com/wb-go/wbf/config"
+)
+
+// Типы операций, из которых собирается цепочка варианта
+const (
+	OpResize    = "resize"
+	OpFit       = "fit"
+	OpFill      = "fill"
+	OpCrop      = "crop"
+	OpBlur      = "blur"
+	OpSharpen   = "sharpen"
+	OpGrayscale = "grayscale"
+)
+
+// Имена вариантов, пути к которым дублируются в processed_path и thumbnail_path
+const (
+	VariantProcessed = "processed"
+	VariantThumb     = "thumb"
+)
+
+// DefaultQuality - качество кодирования, если оно не задано
+const DefaultQuality = 90
+
+const maxDimension = 10000
+
+var anchors = map[string]imaging.Anchor{
+	"":             imaging.Center,
+	"center":       imaging.Center,
+	"top_left":     imaging.TopLeft,
+	"top":          imaging.Top,
+	"top_right":    imaging.TopRight,
+	"left":         imaging.Left,
+	"right":        imaging.Right,
+	"bottom_left":  imaging.BottomLeft,
+	"bottom":       imaging.Bottom,
+	"bottom_right": imaging.BottomRight,
+}
+
+var formats = map[string]string{
+	"":     "",
+	"jpeg": ".jpg",
+	"jpg":  ".jpg",
+	"png":  ".png",
+	"gif":  ".gif",
+	"webp": ".webp",
+}
+
+// Operation - один шаг обработки изображения
+type Operation struct {
+	Type   string  `mapstructure:"type"`
+	Width  int     `mapstructure:"width"`
+	Height int     `mapstructure:"height"`
+	Anchor string  `mapstructure:"anchor"`
+	Sigma  float64 `mapstructure:"sigma"`
+}
+
+// Variant - именованная версия изображения со своей цепочкой операций и параметрами вывода
+type Variant struct {
+	Name       string      `mapstructure:"name"`
+	Operations []Operation `mapstructure:"operations"`
+	Format     string      `mapstructure:"format"`
+	Quality    int         `mapstructure:"quality"`
+	Watermark  bool        `mapstructure:"watermark"`
+}
+
+// Pipeline - набор вариантов, которые воркер строит для каждого изображения
+type Pipeline struct {
+	Variants  []Variant `mapstructure:"variants"`
+	watermark image.Image
+	metadata  string
+}
+
+// Default возвращает конвейер, повторяющий прежнее поведение: processed 1280px и thumb 300px
+func Default() *Pipeline {
+	return &Pipeline{
+		Variants: []Variant{
+			{
+				Name:       VariantProcessed,
+				Operations: []Operation{{Type: OpResize, Width: 1280}},
+				Quality:    DefaultQuality,
+				Watermark:  true,
+			},
+			{
+				Name:       VariantThumb,
+				Operations: []Operation{{Type: OpResize, Width: 300}},
+				Quality:    DefaultQuality,
+				Watermark:  true,
+			},
+		},
+	}
+}
+
+// Load читает описание конвейера из секции pipeline конфигурационного файла
+func Load(path string) (*Pipeline, error) {
+	cfg := config.New()
+	err := cfg.LoadConfigFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("[pipeline] failed to load config: %w", err)
+	}
+
+	p := &Pipeline{}
+	err = cfg.UnmarshalKey("pipeline", p)
+	if err != nil {
+		return nil, fmt.Errorf("[pipeline] failed to parse config: %w", err)
+	}
+
+	err = p.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate проверяет имена вариантов и параметры операций
+func (p *Pipeline) Validate() error {
+	if len(p.Variants) == 0 {
+		return fmt.Errorf("[pipeline] no variants configured")
+	}
+
+	names := make(map[string]struct{}, len(p.Variants))
+	for _, v := range p.Variants {
+		if v.Name == "" || strings.ContainsAny(v.Name, `/\.`) {
+			return fmt.Errorf("[pipeline] invalid variant name %q", v.Name)
+		}
+		if _, ok := names[v.Name]; ok {
+			return fmt.Errorf("[pipeline] duplicate variant %q", v.Name)
+		}
+		names[v.Name] = struct{}{}
+
+		err := v.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Variant возвращает вариант по имени
+func (p *Pipeline) Variant(name string) (Variant, bool) {
+	for _, v := range p.Variants {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Variant{}, false
+}
+
+// WithOptions возвращает копию конвейера с учетом параметров, переданных при загрузке:
+// список вариантов ограничивается запрошенными, размеры переопределяют вариант processed,
+// формат, качество и водяной знак - все выбранные варианты
+func (p *Pipeline) WithOptions(opts *model.ProcessingOptions) (*Pipeline, error) {
+	if opts == nil {
+		return p, nil
+	}
+	if opts.Width < 0 || opts.Height < 0 || opts.Width > maxDimension || opts.Height > maxDimension {
+		return nil, fmt.Errorf("[pipeline] width and height must be in 0..%d", maxDimension)
+	}
+	switch opts.Fit {
+	case "", OpResize, OpFit, OpFill:
+	default:
+		return nil, fmt.Errorf("[pipeline] unsupported fit mode %q", opts.Fit)
+	}
+	if opts.Quality < 0 || opts.Quality > 100 {
+		return nil, fmt.Errorf("[pipeline] quality must be in 1..100")
+	}
+
+	selected := p.Variants
+	if len(opts.Variants) > 0 {
+		selected = make([]Variant, 0, len(opts.Variants))
+		for _, name := range opts.Variants {
+			v, ok := p.Variant(name)
+			if !ok {
+				return nil, fmt.Errorf("[pipeline] unknown variant %q", name)
+			}
+			selected = append(selected, v)
+		}
+	}
+
+	resized := opts.Width == 0 && opts.Height == 0
+	out := &Pipeline{Variants: make([]Variant, 0, len(selected)), watermark: p.watermark, metadata: p.metadata}
+	for _, v := range selected {
+		if v.Name == VariantProcessed && !resized {
+			fit := opts.Fit
+			if fit == "" {
+				fit = OpResize
+			}
+			v.Operations = []Operation{{Type: fit, Width: opts.Width, Height: opts.Height}}
+			resized = true
+		}
+		if opts.Format != "" {
+			v.Format = opts.Format
+		}
+		if opts.Quality > 0 {
+			v.Quality = opts.Quality
+		}
+		if opts.Watermark != nil {
+			v.Watermark = *opts.Watermark
+		}
+		out.Variants = append(out.Variants, v)
+	}
+	if !resized {
+		return nil, fmt.Errorf("[pipeline] width and height apply to variant %q which is not selected", VariantProcessed)
+	}
+
+	err := out.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SetWatermark задает водяной знак для вариантов с включенным watermark
+func (p *Pipeline) SetWatermark(wm image.Image) {
+	p.watermark = wm
+}
+
+// SetMetadataPolicy задает, какие метаданные оригинала попадают в производные изображения:
+// MetadataStripAll - никакие, MetadataStripGPS - все, кроме GPS и встроенной миниатюры
+func (p *Pipeline) SetMetadataPolicy(policy string) error {
+	switch policy {
+	case MetadataStripAll, MetadataStripGPS:
+		p.metadata = policy
+		return nil
+	}
+	return fmt.Errorf("[pipeline] unknown metadata policy %q", policy)
+}
+
+// Render применяет к изображению операции варианта и, если он включен, водяной знак
+func (p *Pipeline) Render(v Variant, img image.Image) image.Image {
+	img = v.Apply(img)
+	if v.Watermark && p.watermark != nil {
+		img = applyWatermark(img, p.watermark)
+	}
+	return img
+}
+
+// Validate проверяет параметры вывода и операции варианта
+func (v Variant) Validate() error {
+	if _, ok := formats[strings.ToLower(v.Format)]; !ok {
+		return fmt.Errorf("[pipeline] variant %q: unsupported format %q", v.Name, v.Format)
+	}
+	if v.Quality < 0 || v.Quality > 100 {
+		return fmt.Errorf("[pipeline] variant %q: quality must be in 1..100", v.Name)
+	}
+	for _, op := range v.Operations {
+		err := op.validate()
+		if err != nil {
+			return fmt.Errorf("[pipeline] variant %q: %w", v.Name, err)
+		}
+	}
+	return nil
+}
+
+// Apply последовательно применяет операции варианта к изображению
+func (v Variant) Apply(img image.Image) image.Image {
+	for _, op := range v.Operations {
+		img = op.apply(img)
+	}
+	return img
+}
+
+// Ext возвращает расширение результата: формат варианта либо формат оригинала
+func (v Variant) Ext(origPath string) string {
+	ext := formats[strings.ToLower(v.Format)]
+	if ext == "" {
+		ext = strings.ToLower(filepath.Ext(origPath))
+	}
+	return ext
+}
+
+// OutputQuality возвращает качество кодирования с учетом значения по умолчанию
+func (v Variant) OutputQuality() int {
+	if v.Quality == 0 {
+		return DefaultQuality
+	}
+	return v.Quality
+}
+
+func (op Operation) validate() error {
+	switch op.Type {
+	case OpResize:
+		if op.Width <= 0 && op.Height <= 0 {
+			return fmt.Errorf("%s: width or height is required", op.Type)
+		}
+	case OpFit, OpFill, OpCrop:
+		if op.Width <= 0 || op.Height <= 0 {
+			return fmt.Errorf("%s: width and height are required", op.Type)
+		}
+		if _, ok := anchors[op.Anchor]; !ok {
+			return fmt.Errorf("%s: unknown anchor %q", op.Type, op.Anchor)
+		}
+	case OpBlur, OpSharpen:
+		if op.Sigma <= 0 {
+			return fmt.Errorf("%s: sigma must be positive", op.Type)
+		}
+	case OpGrayscale:
+	default:
+		return fmt.Errorf("unknown operation %q", op.Type)
+	}
+	return nil
+}
+
+func (op Operation) apply(img image.Image) image.Image {
+	switch op.Type {
+	case OpResize:
+		return imaging.Resize(img, op.Width, op.Height, imaging.Lanczos)
+	case OpFit:
+		return imaging.Fit(img, op.Width, op.Height, imaging.Lanczos)
+	case OpFill:
+		return imaging.Fill(img, op.Width, op.Height, anchors[op.Anchor], imaging.Lanczos)
+	case OpCrop:
+		return imaging.CropAnchor(img, op.Width, op.Height, anchors[op.Anchor])
+	case OpBlur:
+		return imaging.Blur(img, op.Sigma)
+	case OpSharpen:
+		return imaging.Sharpen(img, op.Sigma)
+	case OpGrayscale:
+		return imaging.Grayscale(img)
+	}
+	return img
+}
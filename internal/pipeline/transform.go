@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// Режимы вписывания для преобразования на лету
+const (
+	FitContain = "contain"
+	FitCover   = "cover"
+	FitScale   = "scale"
+)
+
+var ErrInvalidTransform = errors.New("invalid transform")
+
+// Transform - параметры преобразования изображения на лету
+type Transform struct {
+	Width     int
+	Height    int
+	Fit       string
+	Format    string
+	Quality   int
+	Watermark bool
+}
+
+// TransformConfig - допустимые размеры и качество преобразований; значения вне списков отклоняются,
+// чтобы нельзя было заполнить кэш произвольными комбинациями параметров
+type TransformConfig struct {
+	Widths    []int
+	Heights   []int
+	Qualities []int
+	Watermark bool
+}
+
+// Normalize проверяет параметры по списку допустимых значений и подставляет значения по умолчанию
+func (t Transform) Normalize(cfg TransformConfig) (Transform, error) {
+	if t.Width == 0 && t.Height == 0 {
+		return t, fmt.Errorf("[pipeline] %w: width or height is required", ErrInvalidTransform)
+	}
+	if t.Width != 0 && !slices.Contains(cfg.Widths, t.Width) {
+		return t, fmt.Errorf("[pipeline] %w: width %d is not allowed", ErrInvalidTransform, t.Width)
+	}
+	if t.Height != 0 && !slices.Contains(cfg.Heights, t.Height) {
+		return t, fmt.Errorf("[pipeline] %w: height %d is not allowed", ErrInvalidTransform, t.Height)
+	}
+
+	t.Fit = strings.ToLower(t.Fit)
+	switch t.Fit {
+	case "":
+		t.Fit = FitContain
+	case FitContain, FitCover, FitScale:
+	default:
+		return t, fmt.Errorf("[pipeline] %w: unsupported fit %q", ErrInvalidTransform, t.Fit)
+	}
+
+	t.Format = strings.ToLower(t.Format)
+	if _, ok := formats[t.Format]; !ok {
+		return t, fmt.Errorf("[pipeline] %w: unsupported format %q", ErrInvalidTransform, t.Format)
+	}
+
+	if t.Quality == 0 {
+		t.Quality = DefaultQuality
+	} else if !slices.Contains(cfg.Qualities, t.Quality) {
+		return t, fmt.Errorf("[pipeline] %w: quality %d is not allowed", ErrInvalidTransform, t.Quality)
+	}
+
+	t.Watermark = cfg.Watermark
+	return t, nil
+}
+
+// Key возвращает строку, однозначно описывающую параметры преобразования
+func (t Transform) Key() string {
+	return fmt.Sprintf("w%d-h%d-%s-%s-q%d-wm%t", t.Width, t.Height, t.Fit, t.Format, t.Quality, t.Watermark)
+}
+
+// Ext возвращает расширение результата: запрошенный формат либо формат оригинала
+func (t Transform) Ext(origPath string) string {
+	ext := formats[t.Format]
+	if ext == "" {
+		ext = strings.ToLower(filepath.Ext(origPath))
+	}
+	return ext
+}
+
+// Transform строит изображение по параметрам преобразования
+func (p *Pipeline) Transform(img image.Image, t Transform) image.Image {
+	switch {
+	case t.Fit == FitScale || t.Width == 0 || t.Height == 0:
+		img = ResizeImage(img, t.Width, t.Height)
+	case t.Fit == FitCover:
+		img = CreateThumbnail(img, t.Width, t.Height)
+	default:
+		img = imaging.Fit(img, t.Width, t.Height, imaging.Lanczos)
+	}
+	if t.Watermark && p.watermark != nil {
+		img = applyWatermark(img, p.watermark)
+	}
+	return img
+}
@@ -1,10 +1,18 @@
 package kafka
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+
+	"github.com/IBM/sarama"
+)
 
 type Client struct {
 	*Producer
 	*Consumer
+	// metadata - отдельный клиент для проверки доступности брокеров
+	metadata sarama.Client
+	topic    string
 }
 
 func NewKafkaClient(cfg *Config) (*Client, error) {
@@ -17,13 +25,41 @@ func NewKafkaClient(cfg *Config) (*Client, error) {
 		producer.Close()
 		return nil, fmt.Errorf("[kafka-client] error init kafka-consumer: %w", err)
 	}
+	metadata, err := sarama.NewClient(cfg.Brokers, cfg.SaramaConfig())
+	if err != nil {
+		producer.Close()
+		consumer.Close()
+		return nil, fmt.Errorf("[kafka-client] error init metadata client: %w", err)
+	}
 	return &Client{
 		Producer: producer,
 		Consumer: consumer,
+		metadata: metadata,
+		topic:    cfg.Topic,
 	}, nil
 }
 
+// CheckBrokers запрашивает у брокеров метаданные топика заданий
+func (c *Client) CheckBrokers(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- c.metadata.RefreshMetadata(c.topic)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("[kafka-client] brokers unreachable: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (c *Client) Close() error {
+	if c.metadata != nil {
+		c.metadata.Close()
+	}
 	if c.Producer != nil {
 		c.Producer.Close()
 	}
@@ -1,11 +1,32 @@
 package kafka
 
-import "github.com/IBM/sarama"
+import (
+	"log/slog"
+	"time"
+
+	"github.com/IBM/sarama"
+)
 
 type Config struct {
-	Brokers []string
-	Topic   string
-	GroupID string
+	Brokers         []string
+	Topic           string
+	RetryTopic      string
+	DeadLetterTopic string
+	GroupID         string
+	// Workers - число воркеров, обрабатывающих сообщения параллельно (по умолчанию - число CPU)
+	Workers int
+	// MaxInFlight - сколько сообщений может быть в обработке одновременно (по умолчанию 2*Workers)
+	MaxInFlight int
+	// RetryTiers - задержки топиков повторов по возрастанию (по умолчанию DefaultRetryTiers)
+	RetryTiers []time.Duration
+	// Logger - логгер производителя и потребителя (по умолчанию slog.Default)
+	Logger *slog.Logger
+}
+
+// DefaultRetryTiers - уровни задержки повторов: на каждый уровень свой топик <retry>.<задержка>
+var DefaultRetryTiers = []time.Duration{
+	time.Second, 5 * time.Second, 15 * time.Second, 30 * time.Second,
+	time.Minute, 2 * time.Minute, 5 * time.Minute, 15 * time.Minute,
 }
 
 func (c *Config) SaramaConfig() *sarama.Config {
@@ -17,3 +38,27 @@ func (c *Config) SaramaConfig() *sarama.Config {
 	cfg.Consumer.Offsets.Initial = sarama.OffsetOldest
 	return cfg
 }
+
+// retryTopic возвращает топик повторных попыток, по умолчанию <topic>.retry
+func (c *Config) retryTopic() string {
+	if c.RetryTopic != "" {
+		return c.RetryTopic
+	}
+	return c.Topic + ".retry"
+}
+
+// retryTiers возвращает уровни задержки повторов
+func (c *Config) retryTiers() []time.Duration {
+	if len(c.RetryTiers) > 0 {
+		return c.RetryTiers
+	}
+	return DefaultRetryTiers
+}
+
+// deadLetterTopic возвращает dead-letter топик, по умолчанию <topic>.dlq
+func (c *Config) deadLetterTopic() string {
+	if c.DeadLetterTopic != "" {
+		return c.DeadLetterTopic
+	}
+	return c.Topic + ".dlq"
+}
@@ -2,14 +2,30 @@ package kafka
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/IBM/sarama"
+	"github.com/Vladimirmoscow84/Image_processor/internal/metrics"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
 )
 
+// lagInterval - период обновления метрики отставания потребителя
+const lagInterval = 5 * time.Second
+
 type Consumer struct {
-	group sarama.ConsumerGroup
-	topic string
+	group       sarama.ConsumerGroup
+	topics      []string
+	workers     int
+	maxInFlight int
+	log         *slog.Logger
+	groupID     string
+	// joined - потребитель состоит в группе и получил партиции (между Setup и Cleanup сессии)
+	joined atomic.Bool
 }
 
 func NewConsumer(cfg *Config) (*Consumer, error) {
@@ -18,36 +34,137 @@ func NewConsumer(cfg *Config) (*Consumer, error) {
 		return nil, err
 	}
 
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	maxInFlight := cfg.MaxInFlight
+	if maxInFlight < workers {
+		maxInFlight = 2 * workers
+	}
+
+	// общий топик повторов читается для сообщений, отправленных до появления уровней
+	topics := []string{cfg.Topic, cfg.retryTopic()}
+	for _, tier := range newRetryTiers(cfg.retryTopic(), cfg.retryTiers()) {
+		topics = append(topics, tier.topic)
+	}
+
 	return &Consumer{
-		group: group,
-		topic: cfg.Topic,
+		group:       group,
+		topics:      topics,
+		workers:     workers,
+		maxInFlight: maxInFlight,
+		groupID:     cfg.GroupID,
+		log:         logger.OrDefault(cfg.Logger).With("component", "kafka-consumer"),
 	}, nil
 }
 
-// consumerGroupHandler оборачивает функцию обработки сообщений
+// consumerGroupHandler раздает сообщения партиций пулу воркеров
 type consumerGroupHandler struct {
-	handler func(string) error
+	pool   *workerPool
+	log    *slog.Logger
+	joined *atomic.Bool
+}
+
+func (h *consumerGroupHandler) Setup(s sarama.ConsumerGroupSession) error {
+	h.joined.Store(true)
+	return nil
 }
 
-func (h *consumerGroupHandler) Setup(s sarama.ConsumerGroupSession) error   { return nil }
-func (h *consumerGroupHandler) Cleanup(s sarama.ConsumerGroupSession) error { return nil }
+func (h *consumerGroupHandler) Cleanup(s sarama.ConsumerGroupSession) error {
+	h.joined.Store(false)
+	return nil
+}
+
+// ConsumeClaim передает сообщения партиции в пул, не дожидаясь их обработки, и подтверждает
+// смещения по порядку. Отложенное сообщение топика повторов выдерживается здесь же
+// (см. waitDue). При ошибке обработчика новые сообщения не раздаются: после завершения
+// уже начатых ConsumeClaim возвращает ошибку, и неподтвержденные сообщения придут снова
 func (h *consumerGroupHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
-	for msg := range claim.Messages() {
-		err := h.handler(string(msg.Value))
-		if err != nil {
-			log.Printf("[kafka-consumer] handler error: %v", err)
+	ctx := sess.Context()
+	tracker := &offsetTracker{sess: sess, next: claim.InitialOffset()}
+	stop := make(chan struct{})
+	lagDone := make(chan struct{})
+	defer close(lagDone)
+	go reportLag(claim, tracker, lagDone)
+	var (
+		once     sync.Once
+		claimErr error
+		wg       sync.WaitGroup
+	)
+
+loop:
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				break loop
+			}
+			if !waitDue(ctx, stop, msg) || !h.pool.acquire(ctx) {
+				break loop
+			}
+			pending := tracker.add(msg)
+			wg.Add(1)
+			h.pool.submit(task{
+				ctx: ctx,
+				msg: msg,
+				done: func(err error) {
+					defer wg.Done()
+					defer h.pool.release()
+					if err != nil {
+						// сообщение не помечается: после перезапуска сессии оно будет доставлено повторно
+						h.log.Error("handler error, message will be redelivered",
+							"topic", msg.Topic, "partition", msg.Partition, "offset", msg.Offset, logger.Err(err))
+						once.Do(func() {
+							claimErr = err
+							close(stop)
+						})
+					}
+					tracker.complete(pending, err)
+				},
+			})
+		case <-stop:
+			break loop
+		case <-ctx.Done():
+			break loop
 		}
-		sess.MarkMessage(msg, "")
 	}
-	return nil
+
+	// смещения, помеченные после возврата из ConsumeClaim, не будут зафиксированы
+	wg.Wait()
+	return claimErr
 }
 
-func (c *Consumer) Consume(ctx context.Context, handler func(string) error) error {
-	h := &consumerGroupHandler{handler: handler}
+// reportLag раз в lagInterval, пока не закрыт done, обновляет отставание партиции: сколько сообщений
+// между high water mark и первым неподтвержденным. Метрика растет, даже если обработка встала
+// и новые сообщения не читаются. Когда партиция отозвана (ConsumeClaim завершился), метрика
+// партиции удаляется: ее теперь обновляет другой участник группы
+func reportLag(claim sarama.ConsumerGroupClaim, tracker *offsetTracker, done <-chan struct{}) {
+	ticker := time.NewTicker(lagInterval)
+	defer ticker.Stop()
 	for {
-		err := c.group.Consume(ctx, []string{c.topic}, h)
+		select {
+		case <-done:
+			metrics.DeleteConsumerLag(claim.Topic(), claim.Partition())
+			return
+		case <-ticker.C:
+			next, ok := tracker.position()
+			if ok {
+				metrics.SetConsumerLag(claim.Topic(), claim.Partition(), max(claim.HighWaterMarkOffset()-next, 0))
+			}
+		}
+	}
+}
+
+func (c *Consumer) Consume(ctx context.Context, handler func(ctx context.Context, msg []byte) error) error {
+	pool := newWorkerPool(c.workers, c.maxInFlight, handler)
+	defer pool.close()
+
+	h := &consumerGroupHandler{pool: pool, log: c.log, joined: &c.joined}
+	for {
+		err := c.group.Consume(ctx, c.topics, h)
 		if err != nil {
-			log.Printf("[kafka-consumer] error during consuming: %v", err)
+			c.log.Error("error during consuming", logger.Err(err))
 			return err
 		}
 		if ctx.Err() != nil {
@@ -56,6 +173,15 @@ func (c *Consumer) Consume(ctx context.Context, handler func(string) error) erro
 	}
 }
 
+// CheckJoined возвращает ошибку, если потребитель не состоит в группе: чтение не запущено
+// или идет перебалансировка
+func (c *Consumer) CheckJoined(ctx context.Context) error {
+	if !c.joined.Load() {
+		return fmt.Errorf("[kafka-consumer] not joined to consumer group %s", c.groupID)
+	}
+	return nil
+}
+
 func (c *Consumer) Close() error {
 	if c.group != nil {
 		return c.group.Close()
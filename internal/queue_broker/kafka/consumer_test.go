@@ -0,0 +1,36 @@
+package kafka
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/metrics"
+)
+
+func scrapeMetrics(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return rec.Body.String()
+}
+
+// TestReportLagDeletesRevokedPartition проверяет, что после отзыва партиции ее отставание
+// пропадает из метрик, а не остается последним значением
+func TestReportLagDeletesRevokedPartition(t *testing.T) {
+	claim := &fakeClaim{topic: "images.lagtest"}
+	const series = `kafka_consumer_lag{partition="0",topic="images.lagtest"}`
+
+	metrics.SetConsumerLag(claim.Topic(), claim.Partition(), 42)
+	if !strings.Contains(scrapeMetrics(t), series) {
+		t.Fatalf("lag series %s not exported", series)
+	}
+
+	done := make(chan struct{})
+	close(done)
+	reportLag(claim, &offsetTracker{next: -1}, done)
+
+	if strings.Contains(scrapeMetrics(t), series) {
+		t.Fatalf("lag series %s left after partition revoked", series)
+	}
+}
@@ -0,0 +1,98 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// notBeforeHeader - заголовок с моментом (Unix, мс), раньше которого сообщение не обрабатывается
+const notBeforeHeader = "not-before"
+
+// retryTier - топик повторов с фиксированной задержкой. Все сообщения топика ждут одно и то же
+// время от отправки, поэтому в партиции момент доставки только растет, и сообщение, ожидающее
+// в голове партиции, не задерживает более ранние
+type retryTier struct {
+	topic string
+	delay time.Duration
+}
+
+// newRetryTiers строит топики уровней: <base>.<задержка>, например images.retry.30s
+func newRetryTiers(base string, delays []time.Duration) []retryTier {
+	tiers := make([]retryTier, len(delays))
+	for i, d := range delays {
+		tiers[i] = retryTier{topic: base + "." + tierSuffix(d), delay: d}
+	}
+	return tiers
+}
+
+func tierSuffix(d time.Duration) string {
+	switch {
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	case d%time.Second == 0:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+	return fmt.Sprintf("%dms", d/time.Millisecond)
+}
+
+// tierFor возвращает наименьший уровень, задержка которого не меньше delay;
+// задержка больше самого длинного уровня сокращается до него
+func tierFor(tiers []retryTier, delay time.Duration) retryTier {
+	for _, t := range tiers {
+		if t.delay >= delay {
+			return t
+		}
+	}
+	return tiers[len(tiers)-1]
+}
+
+// delayHeaders возвращает заголовок отложенной доставки; для прошедшего момента - ничего
+func delayHeaders(notBefore time.Time) []sarama.RecordHeader {
+	if !notBefore.After(time.Now()) {
+		return nil
+	}
+	return []sarama.RecordHeader{{
+		Key:   []byte(notBeforeHeader),
+		Value: []byte(strconv.FormatInt(notBefore.UnixMilli(), 10)),
+	}}
+}
+
+// notBefore возвращает момент отложенной доставки сообщения; без заголовка - нулевое время
+func notBefore(msg *sarama.ConsumerMessage) time.Time {
+	for _, h := range msg.Headers {
+		if string(h.Key) != notBeforeHeader {
+			continue
+		}
+		ms, err := strconv.ParseInt(string(h.Value), 10, 64)
+		if err != nil {
+			return time.Time{}
+		}
+		return time.UnixMilli(ms)
+	}
+	return time.Time{}
+}
+
+// waitDue ждет момента доставки сообщения в горутине партиции, не занимая воркер пула.
+// Пока сообщение не готово, следующие сообщения партиции не читаются - партиция стоит на паузе;
+// в топике уровня они и так готовы не раньше (см. retryTier). false - ожидание прервано
+// через ctx или stop
+func waitDue(ctx context.Context, stop <-chan struct{}, msg *sarama.ConsumerMessage) bool {
+	d := time.Until(notBefore(msg))
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
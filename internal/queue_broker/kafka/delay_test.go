@@ -0,0 +1,159 @@
+package kafka
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// fakeProducer запоминает отправленные сообщения вместо отправки в брокер
+type fakeProducer struct {
+	sarama.SyncProducer
+
+	mu   sync.Mutex
+	sent []*sarama.ProducerMessage
+}
+
+func (p *fakeProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sent = append(p.sent, msg)
+	return 0, int64(len(p.sent) - 1), nil
+}
+
+// fakeClaim отдает заранее заданные сообщения одной партиции
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	topic string
+	msgs  chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Topic() string                            { return c.topic }
+func (c *fakeClaim) Partition() int32                         { return 0 }
+func (c *fakeClaim) InitialOffset() int64                     { return 0 }
+func (c *fakeClaim) HighWaterMarkOffset() int64               { return int64(len(c.msgs)) }
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.msgs }
+
+// consumed превращает отправленное сообщение в прочитанное из партиции
+func consumed(t *testing.T, msg *sarama.ProducerMessage, offset int64) *sarama.ConsumerMessage {
+	t.Helper()
+	key, err := msg.Key.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := msg.Value.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := &sarama.ConsumerMessage{Topic: msg.Topic, Key: key, Value: value, Offset: offset}
+	for i := range msg.Headers {
+		cm.Headers = append(cm.Headers, &msg.Headers[i])
+	}
+	return cm
+}
+
+func TestTierFor(t *testing.T) {
+	tiers := newRetryTiers("images.retry", DefaultRetryTiers)
+	cases := []struct {
+		delay time.Duration
+		want  string
+	}{
+		{0, "images.retry.1s"},
+		{time.Second, "images.retry.1s"},
+		{3 * time.Second, "images.retry.5s"},
+		{40 * time.Second, "images.retry.1m"},
+		{90 * time.Second, "images.retry.2m"},
+		{time.Hour, "images.retry.15m"},
+	}
+	for _, tc := range cases {
+		if got := tierFor(tiers, tc.delay).topic; got != tc.want {
+			t.Errorf("tierFor(%s) = %s, want %s", tc.delay, got, tc.want)
+		}
+	}
+	if got := tierSuffix(250 * time.Millisecond); got != "250ms" {
+		t.Errorf("tierSuffix(250ms) = %s", got)
+	}
+}
+
+// TestRetryEarlyNotBlockedByLate проверяет, что повтор с близким сроком, отправленный после
+// повтора с далеким, обрабатывается в срок, а не ждет в партиции за ним
+func TestRetryEarlyNotBlockedByLate(t *testing.T) {
+	fake := &fakeProducer{}
+	p := &Producer{
+		retryTiers: newRetryTiers("images.retry", []time.Duration{50 * time.Millisecond, time.Minute}),
+		producer:   fake,
+		log:        slog.New(slog.DiscardHandler),
+	}
+	ctx := context.Background()
+	if err := p.ProduceRetry(ctx, "1", []byte("late"), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	sentEarly := time.Now()
+	if err := p.ProduceRetry(ctx, "2", []byte("early"), time.Now().Add(10*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	late, early := fake.sent[0], fake.sent[1]
+	if late.Topic != "images.retry.1m" || early.Topic != "images.retry.50ms" {
+		t.Fatalf("retry topics = %s, %s", late.Topic, early.Topic)
+	}
+
+	// сообщения одной партиции топика отдаются по порядку: сначала далекий повтор, потом близкий
+	claims := make(map[string]*fakeClaim)
+	for _, msg := range fake.sent {
+		c, ok := claims[msg.Topic]
+		if !ok {
+			c = &fakeClaim{topic: msg.Topic, msgs: make(chan *sarama.ConsumerMessage, len(fake.sent))}
+			claims[msg.Topic] = c
+		}
+		c.msgs <- consumed(t, msg, int64(len(c.msgs)))
+	}
+
+	var (
+		earlyAt     atomic.Int64
+		handledLate atomic.Bool
+	)
+	pool := newWorkerPool(2, 4, func(ctx context.Context, msg []byte) error {
+		switch string(msg) {
+		case "early":
+			earlyAt.Store(time.Now().UnixNano())
+		case "late":
+			handledLate.Store(true)
+		}
+		return nil
+	})
+	defer pool.close()
+	h := &consumerGroupHandler{pool: pool, log: slog.New(slog.DiscardHandler), joined: &atomic.Bool{}}
+
+	sessCtx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, c := range claims {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.ConsumeClaim(&fakeSession{ctx: sessCtx}, c)
+		}()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for earlyAt.Load() == 0 {
+		if time.Now().After(deadline) {
+			cancel()
+			wg.Wait()
+			t.Fatal("early retry was not handled in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if d := time.Unix(0, earlyAt.Load()).Sub(sentEarly); d < 40*time.Millisecond {
+		t.Fatalf("early retry handled %s after sending, before its tier delay", d)
+	}
+	if handledLate.Load() {
+		t.Fatal("late retry handled before it was due")
+	}
+	cancel()
+	wg.Wait()
+}
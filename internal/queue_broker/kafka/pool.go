@@ -0,0 +1,144 @@
+package kafka
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"sync"
+
+	"github.com/IBM/sarama"
+	"github.com/Vladimirmoscow84/Image_processor/internal/tracing"
+)
+
+// task - сообщение, переданное воркеру, и функция, сообщающая о завершении его обработки
+type task struct {
+	ctx  context.Context
+	msg  *sarama.ConsumerMessage
+	done func(err error)
+}
+
+// workerPool - пул воркеров, общий для всех партиций. Каждый воркер обслуживает свою полосу:
+// сообщения с одинаковым ключом (ID изображения) попадают в одну полосу и выполняются по порядку,
+// сообщения с разными ключами - параллельно. Число сообщений в работе ограничено семафором
+type workerPool struct {
+	handler func(ctx context.Context, msg []byte) error
+	lanes   []chan task
+	slots   chan struct{}
+	wg      sync.WaitGroup
+}
+
+func newWorkerPool(workers, maxInFlight int, handler func(ctx context.Context, msg []byte) error) *workerPool {
+	p := &workerPool{
+		handler: handler,
+		lanes:   make([]chan task, workers),
+		slots:   make(chan struct{}, maxInFlight),
+	}
+	for i := range p.lanes {
+		p.lanes[i] = make(chan task, maxInFlight)
+		p.wg.Add(1)
+		go p.run(p.lanes[i])
+	}
+	return p
+}
+
+func (p *workerPool) run(lane chan task) {
+	defer p.wg.Done()
+	for t := range lane {
+		ctx, span := startConsumeSpan(t.ctx, t.msg)
+		err := p.handler(ctx, t.msg.Value)
+		tracing.End(span, err)
+		t.done(err)
+	}
+}
+
+// acquire занимает место для нового сообщения; пока все места заняты, чтение из партиции
+// приостанавливается и новые сообщения остаются в буфере sarama
+func (p *workerPool) acquire(ctx context.Context) bool {
+	select {
+	case p.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (p *workerPool) release() {
+	<-p.slots
+}
+
+// submit передает сообщение в полосу по его ключу
+func (p *workerPool) submit(t task) {
+	p.lanes[p.lane(t.msg)] <- t
+}
+
+// lane возвращает номер полосы сообщения; сообщения без ключа распределяются по смещению
+func (p *workerPool) lane(msg *sarama.ConsumerMessage) int {
+	h := fnv.New32a()
+	if len(msg.Key) > 0 {
+		h.Write(msg.Key)
+	} else {
+		h.Write([]byte(strconv.FormatInt(msg.Offset, 10)))
+	}
+	return int(h.Sum32() % uint32(len(p.lanes)))
+}
+
+// close дожидается завершения всех переданных сообщений и останавливает воркеров
+func (p *workerPool) close() {
+	for _, lane := range p.lanes {
+		close(lane)
+	}
+	p.wg.Wait()
+}
+
+// offsetTracker помечает смещения партиции строго по порядку: сообщение подтверждается,
+// только когда завершены все предыдущие. После ошибки подтверждение останавливается,
+// и необработанные сообщения будут доставлены повторно
+type offsetTracker struct {
+	mu      sync.Mutex
+	sess    sarama.ConsumerGroupSession
+	pending []*pendingMessage
+	failed  bool
+	// next - смещение, с которого партиция будет прочитана после перезапуска; < 0 - пока неизвестно
+	next int64
+}
+
+type pendingMessage struct {
+	msg  *sarama.ConsumerMessage
+	done bool
+}
+
+func (t *offsetTracker) add(msg *sarama.ConsumerMessage) *pendingMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := &pendingMessage{msg: msg}
+	t.pending = append(t.pending, m)
+	if t.next < 0 {
+		t.next = msg.Offset
+	}
+	return m
+}
+
+func (t *offsetTracker) complete(m *pendingMessage, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.failed = true
+		return
+	}
+	m.done = true
+	if t.failed {
+		return
+	}
+	for len(t.pending) > 0 && t.pending[0].done {
+		t.sess.MarkMessage(t.pending[0].msg, "")
+		t.next = t.pending[0].msg.Offset + 1
+		t.pending = t.pending[1:]
+	}
+}
+
+// position возвращает смещение первого неподтвержденного сообщения; false - если оно еще неизвестно
+func (t *offsetTracker) position() (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.next, t.next >= 0
+}
@@ -0,0 +1,247 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// fakeSession запоминает помеченные смещения; остальные методы сессии тестам не нужны
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	ctx context.Context
+
+	mu     sync.Mutex
+	marked []int64
+}
+
+func (s *fakeSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked = append(s.marked, msg.Offset)
+}
+
+func (s *fakeSession) offsets() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.marked)
+}
+
+func messages(offsets ...int64) []*sarama.ConsumerMessage {
+	msgs := make([]*sarama.ConsumerMessage, len(offsets))
+	for i, off := range offsets {
+		msgs[i] = &sarama.ConsumerMessage{Topic: "images", Offset: off}
+	}
+	return msgs
+}
+
+func TestOffsetTracker(t *testing.T) {
+	errHandler := errors.New("handler failed")
+	cases := []struct {
+		name     string
+		offsets  []int64
+		complete []int
+		errAt    int
+		want     []int64
+		wantNext int64
+	}{
+		{
+			name:     "in order",
+			offsets:  []int64{0, 1, 2},
+			complete: []int{0, 1, 2},
+			errAt:    -1,
+			want:     []int64{0, 1, 2},
+			wantNext: 3,
+		},
+		{
+			name:     "out of order",
+			offsets:  []int64{0, 1, 2, 3},
+			complete: []int{2, 1, 3, 0},
+			errAt:    -1,
+			want:     []int64{0, 1, 2, 3},
+			wantNext: 4,
+		},
+		{
+			name:     "unfinished message holds later ones",
+			offsets:  []int64{0, 1, 2},
+			complete: []int{1, 2},
+			errAt:    -1,
+			want:     nil,
+			wantNext: 0,
+		},
+		{
+			name:     "unfinished message in the middle",
+			offsets:  []int64{0, 1, 2, 3},
+			complete: []int{0, 2, 3},
+			errAt:    -1,
+			want:     []int64{0},
+			wantNext: 1,
+		},
+		{
+			name:     "offset gap after compaction",
+			offsets:  []int64{10, 15, 16, 40},
+			complete: []int{3, 1, 0, 2},
+			errAt:    -1,
+			want:     []int64{10, 15, 16, 40},
+			wantNext: 41,
+		},
+		{
+			name:     "failure stops marking",
+			offsets:  []int64{0, 1, 2},
+			complete: []int{0, 1, 2},
+			errAt:    1,
+			want:     []int64{0},
+			wantNext: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			sess := &fakeSession{}
+			tracker := &offsetTracker{sess: sess, next: sarama.OffsetOldest}
+			if _, ok := tracker.position(); ok {
+				t.Fatal("position known before the first message")
+			}
+
+			msgs := messages(tc.offsets...)
+			pending := make([]*pendingMessage, len(msgs))
+			for i, msg := range msgs {
+				pending[i] = tracker.add(msg)
+			}
+			for _, i := range tc.complete {
+				var err error
+				if i == tc.errAt {
+					err = errHandler
+				}
+				tracker.complete(pending[i], err)
+			}
+
+			if got := sess.offsets(); !slices.Equal(got, tc.want) {
+				t.Fatalf("marked = %v, want %v", got, tc.want)
+			}
+			next, ok := tracker.position()
+			if !ok || next != tc.wantNext {
+				t.Fatalf("position = %d, %v, want %d", next, ok, tc.wantNext)
+			}
+		})
+	}
+}
+
+func TestOffsetTrackerInitialOffset(t *testing.T) {
+	tracker := &offsetTracker{sess: &fakeSession{}, next: 42}
+	next, ok := tracker.position()
+	if !ok || next != 42 {
+		t.Fatalf("position = %d, %v, want 42", next, ok)
+	}
+	tracker.complete(tracker.add(messages(42)[0]), nil)
+	if next, _ := tracker.position(); next != 43 {
+		t.Fatalf("position = %d, want 43", next)
+	}
+}
+
+// submitAll передает сообщения пулу так же, как ConsumeClaim, и ждет их обработки
+func submitAll(t *testing.T, p *workerPool, msgs []*sarama.ConsumerMessage) {
+	t.Helper()
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for _, msg := range msgs {
+		if !p.acquire(ctx) {
+			t.Fatal("acquire failed")
+		}
+		wg.Add(1)
+		p.submit(task{ctx: ctx, msg: msg, done: func(error) {
+			defer wg.Done()
+			p.release()
+		}})
+	}
+	wg.Wait()
+}
+
+func TestPoolSameKeyInOrder(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		order   []string
+		running atomic.Int32
+		overlap atomic.Bool
+	)
+	p := newWorkerPool(4, 8, func(ctx context.Context, msg []byte) error {
+		if running.Add(1) > 1 {
+			overlap.Store(true)
+		}
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		order = append(order, string(msg))
+		mu.Unlock()
+		running.Add(-1)
+		return nil
+	})
+	defer p.close()
+
+	var msgs []*sarama.ConsumerMessage
+	var want []string
+	for i := range 10 {
+		v := fmt.Sprintf("job-%d", i)
+		msgs = append(msgs, &sarama.ConsumerMessage{Topic: "images", Key: []byte("42"), Value: []byte(v), Offset: int64(i)})
+		want = append(want, v)
+	}
+	submitAll(t, p, msgs)
+
+	if overlap.Load() {
+		t.Fatal("messages with the same key ran concurrently")
+	}
+	if !slices.Equal(order, want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+}
+
+func TestPoolDifferentKeysInParallel(t *testing.T) {
+	const workers = 4
+
+	// ключи, попадающие в разные полосы
+	lanes := &workerPool{lanes: make([]chan task, workers)}
+	var msgs []*sarama.ConsumerMessage
+	used := make(map[int]bool)
+	for i := 0; len(msgs) < workers; i++ {
+		msg := &sarama.ConsumerMessage{Topic: "images", Key: []byte(fmt.Sprint(i)), Offset: int64(i)}
+		if lane := lanes.lane(msg); !used[lane] {
+			used[lane] = true
+			msgs = append(msgs, msg)
+		}
+	}
+
+	// каждый обработчик ждет, пока стартуют все: при последовательном выполнении ожидание истечет
+	var started sync.WaitGroup
+	started.Add(len(msgs))
+	all := make(chan struct{})
+	go func() {
+		started.Wait()
+		close(all)
+	}()
+	var timedOut atomic.Bool
+	p := newWorkerPool(workers, 2*workers, func(ctx context.Context, msg []byte) error {
+		started.Done()
+		select {
+		case <-all:
+		case <-time.After(2 * time.Second):
+			timedOut.Store(true)
+		}
+		return nil
+	})
+	defer p.close()
+	submitAll(t, p, msgs)
+
+	if timedOut.Load() {
+		t.Fatal("messages with different keys did not run in parallel")
+	}
+}
@@ -2,14 +2,22 @@ package kafka
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"time"
 
 	"github.com/IBM/sarama"
+	"github.com/Vladimirmoscow84/Image_processor/internal/tracing"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Producer struct {
-	topic    string
-	producer sarama.SyncProducer
+	topic           string
+	retryTiers      []retryTier
+	deadLetterTopic string
+	producer        sarama.SyncProducer
+	log             *slog.Logger
 }
 
 func NewProducer(cfg *Config) (*Producer, error) {
@@ -19,19 +27,47 @@ func NewProducer(cfg *Config) (*Producer, error) {
 	}
 
 	return &Producer{
-		topic:    cfg.Topic,
-		producer: prod,
+		topic:           cfg.Topic,
+		retryTiers:      newRetryTiers(cfg.retryTopic(), cfg.retryTiers()),
+		deadLetterTopic: cfg.deadLetterTopic(),
+		producer:        prod,
+		log:             logger.OrDefault(cfg.Logger).With("component", "kafka-producer"),
 	}, nil
 }
 
-func (p *Producer) Produce(ctx context.Context, msg string) error {
+// Produce отправляет сообщение в топик; ключ определяет партицию
+func (p *Producer) Produce(ctx context.Context, key string, msg []byte) error {
+	return p.send(ctx, p.topic, key, msg)
+}
+
+// ProduceRetry отправляет сообщение в топик уровня с наименьшей задержкой не меньше нужной;
+// потребитель возьмет его в обработку через задержку уровня
+func (p *Producer) ProduceRetry(ctx context.Context, key string, msg []byte, notBefore time.Time) error {
+	tier := tierFor(p.retryTiers, time.Until(notBefore))
+	return p.send(ctx, tier.topic, key, msg, delayHeaders(time.Now().Add(tier.delay))...)
+}
+
+// ProduceDeadLetter отправляет сообщение, исчерпавшее попытки, в dead-letter топик
+func (p *Producer) ProduceDeadLetter(ctx context.Context, key string, msg []byte) error {
+	return p.send(ctx, p.deadLetterTopic, key, msg)
+}
+
+// send отправляет сообщение, передавая контекст трассировки из ctx и headers в заголовках
+func (p *Producer) send(ctx context.Context, topic, key string, msg []byte, headers ...sarama.RecordHeader) error {
+	ctx, span := tracer.Start(ctx, topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(semconv.MessagingSystemKafka, semconv.MessagingDestinationName(topic)),
+	)
 	message := &sarama.ProducerMessage{
-		Topic: p.topic,
-		Value: sarama.StringEncoder(msg),
+		Topic:   topic,
+		Key:     sarama.StringEncoder(key),
+		Value:   sarama.ByteEncoder(msg),
+		Headers: append(traceHeaders(ctx), headers...),
 	}
 	_, _, err := p.producer.SendMessage(message)
+	tracing.End(span, err)
 	if err != nil {
-		log.Printf("[kafka-producer] failed to send message: %v", err)
+		p.log.ErrorContext(ctx, "failed to send message", "topic", topic, logger.Err(err))
 		return err
 	}
 	return nil
@@ -0,0 +1,41 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/IBM/sarama"
+	"github.com/Vladimirmoscow84/Image_processor/internal/tracing"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/Vladimirmoscow84/Image_processor/internal/queue_broker/kafka")
+
+// traceHeaders переносит контекст трассировки из ctx в заголовки сообщения
+func traceHeaders(ctx context.Context) []sarama.RecordHeader {
+	var headers []sarama.RecordHeader
+	for k, v := range tracing.Inject(ctx) {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	return headers
+}
+
+// startConsumeSpan открывает спан обработки сообщения, продолжая трассу отправителя из заголовков
+func startConsumeSpan(ctx context.Context, msg *sarama.ConsumerMessage) (context.Context, trace.Span) {
+	headers := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	ctx = tracing.Extract(ctx, headers)
+	return tracer.Start(ctx, msg.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingDestinationName(msg.Topic),
+			semconv.MessagingDestinationPartitionID(strconv.Itoa(int(msg.Partition))),
+			semconv.MessagingKafkaOffset(int(msg.Offset)),
+		),
+	)
+}
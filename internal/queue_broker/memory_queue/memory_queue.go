@@ -0,0 +1,165 @@
+package memoryqueue
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/tracing"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/Vladimirmoscow84/Image_processor/internal/queue_broker/memory_queue")
+
+// requeueDelay - через сколько сообщение возвращается в очередь после ошибки обработчика
+const requeueDelay = time.Second
+
+// Queue - очередь заданий в памяти процесса для разработки и тестов: сообщения не переживают
+// перезапуск. Сообщение, обработчик которого вернул ошибку, возвращается в конец очереди через
+// requeueDelay; отложенные сообщения ждут таймера, а не воркера
+type Queue struct {
+	workers int
+	log     *slog.Logger
+
+	mu      sync.Mutex
+	pending []message
+	dead    [][]byte
+	ready   chan struct{}
+}
+
+type message struct {
+	key     string
+	value   []byte
+	headers map[string]string
+}
+
+// New - конструктор очереди в памяти; workers <= 0 - по числу CPU
+func New(workers int, log *slog.Logger) *Queue {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &Queue{
+		workers: workers,
+		log:     logger.OrDefault(log).With("component", "memory-queue"),
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+// Produce добавляет сообщение в очередь
+func (q *Queue) Produce(ctx context.Context, key string, msg []byte) error {
+	q.push(message{key: key, value: msg, headers: tracing.Inject(ctx)})
+	return nil
+}
+
+// ProduceRetry добавляет сообщение повторной попытки в очередь в момент notBefore
+func (q *Queue) ProduceRetry(ctx context.Context, key string, msg []byte, notBefore time.Time) error {
+	q.pushAt(message{key: key, value: msg, headers: tracing.Inject(ctx)}, notBefore)
+	return nil
+}
+
+// ProduceDeadLetter сохраняет сообщение, исчерпавшее попытки; их можно получить через DeadLetters
+func (q *Queue) ProduceDeadLetter(ctx context.Context, key string, msg []byte) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.dead = append(q.dead, msg)
+	q.log.WarnContext(ctx, "message moved to dead letters", "key", key)
+	return nil
+}
+
+// DeadLetters возвращает сообщения, исчерпавшие попытки
+func (q *Queue) DeadLetters() [][]byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([][]byte(nil), q.dead...)
+}
+
+// Consume обрабатывает сообщения в workers горутинах, пока не отменен ctx;
+// возвращается после завершения начатых обработчиков
+func (q *Queue) Consume(ctx context.Context, handler func(ctx context.Context, msg []byte) error) error {
+	var wg sync.WaitGroup
+	for range q.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				m, ok := q.pop(ctx)
+				if !ok {
+					return
+				}
+				err := q.handle(ctx, m, handler)
+				if err != nil {
+					q.log.Error("handler error, message requeued", "key", m.key, logger.Err(err))
+					q.pushAt(m, time.Now().Add(requeueDelay))
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// Close ничего не освобождает: очередь живет в памяти процесса
+func (q *Queue) Close() error {
+	return nil
+}
+
+// handle выполняет обработчик в спане, продолжающем трассу отправителя
+func (q *Queue) handle(ctx context.Context, m message, handler func(ctx context.Context, msg []byte) error) error {
+	ctx, span := tracer.Start(tracing.Extract(ctx, m.headers), "memory_queue process",
+		trace.WithSpanKind(trace.SpanKindConsumer))
+	err := handler(ctx, m.value)
+	tracing.End(span, err)
+	return err
+}
+
+func (q *Queue) push(m message) {
+	q.mu.Lock()
+	q.pending = append(q.pending, m)
+	q.mu.Unlock()
+	q.notify()
+}
+
+// pushAt добавляет сообщение в очередь в момент t, не занимая воркер ожиданием
+func (q *Queue) pushAt(m message, t time.Time) {
+	d := time.Until(t)
+	if d <= 0 {
+		q.push(m)
+		return
+	}
+	time.AfterFunc(d, func() { q.push(m) })
+}
+
+// pop ждет следующее сообщение; false - если ctx отменен
+func (q *Queue) pop(ctx context.Context) (message, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.pending) > 0 {
+			m := q.pending[0]
+			q.pending = q.pending[1:]
+			more := len(q.pending) > 0
+			q.mu.Unlock()
+			if more {
+				q.notify()
+			}
+			return m, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return message{}, false
+		}
+	}
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
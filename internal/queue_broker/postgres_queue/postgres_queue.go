@@ -0,0 +1,221 @@
+package postgresqueue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/Vladimirmoscow84/Image_processor/internal/tracing"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/Vladimirmoscow84/Image_processor/internal/queue_broker/postgres_queue")
+
+const (
+	topicJobs  = "jobs"
+	topicRetry = "retry"
+	topicDead  = "dead"
+)
+
+// Config - параметры очереди в PostgreSQL
+type Config struct {
+	// Workers - число воркеров (по умолчанию - число CPU)
+	Workers int
+	// PollInterval - пауза опроса, когда готовых сообщений нет (по умолчанию 1s)
+	PollInterval time.Duration
+	// Visibility - на сколько сообщение скрывается от других воркеров; пока обработчик работает,
+	// срок продлевается, а после падения воркера сообщение снова становится доступным (по умолчанию 1m)
+	Visibility time.Duration
+	// Logger - логгер очереди (по умолчанию slog.Default)
+	Logger *slog.Logger
+}
+
+// Queue - очередь заданий в таблице job_queue. Воркеры забирают сообщения через
+// FOR UPDATE SKIP LOCKED, поэтому несколько экземпляров сервиса не получат одно сообщение одновременно
+type Queue struct {
+	db  *sqlx.DB
+	cfg Config
+	log *slog.Logger
+}
+
+type message struct {
+	ID      int64         `db:"id"`
+	Payload []byte        `db:"payload"`
+	Headers model.Headers `db:"headers"`
+}
+
+// New - конструктор очереди поверх подключения к PostgreSQL
+func New(db *sqlx.DB, cfg Config) *Queue {
+	if cfg.Workers <= 0 {
+		cfg.Workers = runtime.NumCPU()
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Second
+	}
+	if cfg.Visibility <= 0 {
+		cfg.Visibility = time.Minute
+	}
+	return &Queue{db: db, cfg: cfg, log: logger.OrDefault(cfg.Logger).With("component", "postgres-queue")}
+}
+
+// Produce добавляет сообщение в очередь
+func (q *Queue) Produce(ctx context.Context, key string, msg []byte) error {
+	return q.insert(ctx, topicJobs, key, msg, time.Time{})
+}
+
+// ProduceRetry добавляет сообщение повторной попытки; claim не выдаст его раньше notBefore
+func (q *Queue) ProduceRetry(ctx context.Context, key string, msg []byte, notBefore time.Time) error {
+	return q.insert(ctx, topicRetry, key, msg, notBefore)
+}
+
+// ProduceDeadLetter сохраняет сообщение, исчерпавшее попытки; такие сообщения не раздаются воркерам
+func (q *Queue) ProduceDeadLetter(ctx context.Context, key string, msg []byte) error {
+	return q.insert(ctx, topicDead, key, msg, time.Time{})
+}
+
+// Consume обрабатывает сообщения в нескольких воркерах, пока не отменен ctx; возвращается
+// после завершения начатых обработчиков. Успешно обработанное сообщение удаляется, после ошибки
+// оно откладывается с экспоненциальной задержкой (не более минуты)
+func (q *Queue) Consume(ctx context.Context, handler func(ctx context.Context, msg []byte) error) error {
+	var wg sync.WaitGroup
+	for range q.cfg.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx, handler)
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// Close ничего не освобождает: подключением к БД владеет хранилище postgres
+func (q *Queue) Close() error {
+	return nil
+}
+
+func (q *Queue) work(ctx context.Context, handler func(ctx context.Context, msg []byte) error) {
+	for ctx.Err() == nil {
+		msg, err := q.claim(ctx)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) && ctx.Err() == nil {
+			q.log.Error("failed to claim message", logger.Err(err))
+		}
+		if err != nil {
+			select {
+			case <-time.After(q.cfg.PollInterval):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		q.handle(ctx, msg, handler)
+	}
+}
+
+// handle выполняет обработчик, продлевая видимость сообщения, и подтверждает или откладывает его.
+// Подтверждение не зависит от отмены ctx: начатое сообщение должно получить итог
+func (q *Queue) handle(ctx context.Context, msg *message, handler func(ctx context.Context, msg []byte) error) {
+	ctx, span := tracer.Start(tracing.Extract(ctx, msg.Headers), "job_queue process",
+		trace.WithSpanKind(trace.SpanKindConsumer))
+	stop := make(chan struct{})
+	go q.extend(ctx, msg.ID, stop)
+	err := handler(ctx, msg.Payload)
+	close(stop)
+	tracing.End(span, err)
+
+	done := context.WithoutCancel(ctx)
+	if err != nil {
+		q.log.ErrorContext(ctx, "handler error, message postponed", "message_id", msg.ID, logger.Err(err))
+		err = q.nack(done, msg.ID, err)
+	} else {
+		err = q.ack(done, msg.ID)
+	}
+	if err != nil {
+		q.log.ErrorContext(ctx, "failed to finish message", "message_id", msg.ID, logger.Err(err))
+	}
+}
+
+// extend продлевает видимость сообщения, пока не закрыт stop
+func (q *Queue) extend(ctx context.Context, id int64, stop chan struct{}) {
+	ticker := time.NewTicker(q.cfg.Visibility / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			_, err := q.db.ExecContext(context.WithoutCancel(ctx), `
+			UPDATE job_queue SET locked_until = NOW() + $2 * INTERVAL '1 second' WHERE id = $1;
+			`, id, q.cfg.Visibility.Seconds())
+			if err != nil {
+				q.log.WarnContext(ctx, "failed to extend message", "message_id", id, logger.Err(err))
+			}
+		}
+	}
+}
+
+// claim забирает самое старое готовое сообщение и скрывает его от других воркеров на cfg.Visibility
+func (q *Queue) claim(ctx context.Context) (*message, error) {
+	var msg message
+	err := q.db.GetContext(ctx, &msg, `
+	UPDATE job_queue
+	SET locked_until = NOW() + $1 * INTERVAL '1 second', attempts = attempts + 1
+	WHERE id = (
+		SELECT id
+		FROM job_queue
+		WHERE topic IN ($2, $3)
+		  AND available_at <= NOW()
+		  AND (locked_until IS NULL OR locked_until < NOW())
+		ORDER BY id
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, payload, headers;
+	`, q.cfg.Visibility.Seconds(), topicJobs, topicRetry)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (q *Queue) ack(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, `DELETE FROM job_queue WHERE id = $1;`, id)
+	if err != nil {
+		return fmt.Errorf("[postgres-queue] failed to delete message: %w", err)
+	}
+	return nil
+}
+
+func (q *Queue) nack(ctx context.Context, id int64, cause error) error {
+	_, err := q.db.ExecContext(ctx, `
+	UPDATE job_queue
+	SET locked_until = NULL,
+		last_error = $2,
+		available_at = NOW() + LEAST(INTERVAL '1 second' * power(2, attempts - 1), INTERVAL '1 minute')
+	WHERE id = $1;
+	`, id, cause.Error())
+	if err != nil {
+		return fmt.Errorf("[postgres-queue] failed to postpone message: %w", err)
+	}
+	return nil
+}
+
+// insert добавляет сообщение, доступное воркерам с availableAt; прошедший момент - сразу
+func (q *Queue) insert(ctx context.Context, topic, key string, msg []byte, availableAt time.Time) error {
+	_, err := q.db.ExecContext(ctx, `
+	INSERT INTO job_queue (topic, key, payload, headers, available_at)
+	VALUES ($1, $2, $3, $4, GREATEST(NOW(), $5));
+	`, topic, key, msg, model.Headers(tracing.Inject(ctx)), availableAt)
+	if err != nil {
+		return fmt.Errorf("[postgres-queue] failed to insert message into %s: %w", topic, err)
+	}
+	return nil
+}
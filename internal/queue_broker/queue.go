@@ -0,0 +1,18 @@
+package queuebroker
+
+import (
+	"context"
+	"time"
+)
+
+// Queue - очередь заданий на обработку: Kafka, таблица в PostgreSQL или очередь в памяти.
+// Доставка - хотя бы один раз: сообщение, обработчик которого вернул ошибку, будет доставлено снова.
+// Сообщение повторной попытки выдерживает очередь: обработчик получит его не раньше notBefore
+// и не ждет сам, занимая воркер
+type Queue interface {
+	Produce(ctx context.Context, key string, msg []byte) error
+	ProduceRetry(ctx context.Context, key string, msg []byte, notBefore time.Time) error
+	ProduceDeadLetter(ctx context.Context, key string, msg []byte) error
+	Consume(ctx context.Context, handler func(ctx context.Context, msg []byte) error) error
+	Close() error
+}
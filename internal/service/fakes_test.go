@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+)
+
+var errNotImplemented = errors.New("not implemented in fake")
+
+// fakeRepo - хранилище записей в памяти с той же семантикой статусов, аренды и outbox, что у postgres
+type fakeRepo struct {
+	mu        sync.Mutex
+	images    map[int]*model.Image
+	owners    map[int]string
+	leases    map[int]time.Time
+	outbox    []*model.OutboxMessage
+	published int
+	processed map[string]bool
+	claims    map[int]int
+	// getErr - ошибка, которую возвращает GetImage вместо записи
+	getErr error
+}
+
+func newFakeRepo(images ...*model.Image) *fakeRepo {
+	r := &fakeRepo{
+		images:    make(map[int]*model.Image),
+		owners:    make(map[int]string),
+		leases:    make(map[int]time.Time),
+		processed: make(map[string]bool),
+		claims:    make(map[int]int),
+	}
+	for _, img := range images {
+		r.images[img.ID] = img
+	}
+	return r
+}
+
+// image возвращает копию записи для проверок в тесте
+func (r *fakeRepo) image(id int) model.Image {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return *r.images[id]
+}
+
+func (r *fakeRepo) claimCount(id int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.claims[id]
+}
+
+func (r *fakeRepo) AddImage(ctx context.Context, image *model.Image, outbox func(id int) (*model.OutboxMessage, error)) (int, bool, error) {
+	return 0, false, errNotImplemented
+}
+
+func (r *fakeRepo) GetImage(ctx context.Context, id int, scope model.OwnerScope) (*model.Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.getErr != nil {
+		return nil, r.getErr
+	}
+	img, ok := r.images[id]
+	if !ok || !scope.Allows(img.OwnerID) {
+		return nil, model.ErrImageNotFound
+	}
+	cp := *img
+	return &cp, nil
+}
+
+func (r *fakeRepo) GetImageByOriginalPath(ctx context.Context, originalPath, ownerID string, opts *model.ProcessingOptions) (*model.Image, error) {
+	return nil, errNotImplemented
+}
+
+func (r *fakeRepo) DeleteImage(ctx context.Context, id int, scope model.OwnerScope) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	img, ok := r.images[id]
+	if !ok || !scope.Allows(img.OwnerID) {
+		return model.ErrImageNotFound
+	}
+	delete(r.images, id)
+	return nil
+}
+
+func (r *fakeRepo) UpdateImageStatus(ctx context.Context, id int, from []model.Status, to model.Status, errMsg string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	img, ok := r.images[id]
+	if !ok {
+		return model.ErrImageNotFound
+	}
+	if !slices.Contains(from, img.Status) {
+		return model.ErrStatusConflict
+	}
+	img.Status = to
+	img.ErrorMessage = errMsg
+	if to != model.StatusProcessing {
+		delete(r.owners, id)
+		delete(r.leases, id)
+	}
+	return nil
+}
+
+func (r *fakeRepo) IsJobProcessed(ctx context.Context, jobID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.processed[jobID], nil
+}
+
+func (r *fakeRepo) ClaimImage(ctx context.Context, id int, from []model.Status, lease *model.Lease) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	img, ok := r.images[id]
+	if !ok {
+		return model.ErrImageNotFound
+	}
+	expired := time.Now().After(r.leases[id])
+	if !slices.Contains(from, img.Status) && !(img.Status == model.StatusProcessing && expired) {
+		if img.Status == model.StatusProcessing {
+			return model.ErrImageLeased
+		}
+		return model.ErrStatusConflict
+	}
+	img.Status = model.StatusProcessing
+	img.Attempts++
+	r.owners[id] = lease.Owner
+	r.leases[id] = time.Now().Add(lease.TTL)
+	r.claims[id]++
+	return nil
+}
+
+func (r *fakeRepo) RenewLease(ctx context.Context, id int, lease *model.Lease) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.owners[id] != lease.Owner {
+		return model.ErrLeaseLost
+	}
+	r.leases[id] = time.Now().Add(lease.TTL)
+	return nil
+}
+
+func (r *fakeRepo) ReleaseImage(ctx context.Context, id int, lease *model.Lease) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	img, ok := r.images[id]
+	if !ok || r.owners[id] != lease.Owner {
+		return model.ErrLeaseLost
+	}
+	img.Status = model.StatusEnqueued
+	img.Attempts--
+	delete(r.owners, id)
+	delete(r.leases, id)
+	return nil
+}
+
+func (r *fakeRepo) CompleteImage(ctx context.Context, img *model.Image, variants []*model.ImageVariant, lease *model.Lease) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.images[img.ID]
+	if !ok || stored.Status != model.StatusProcessing || r.owners[img.ID] != lease.Owner {
+		return model.ErrLeaseLost
+	}
+	stored.Status = model.StatusProcessed
+	stored.ErrorMessage = ""
+	stored.ProcessedPath = img.ProcessedPath
+	stored.ThumbnailPath = img.ThumbnailPath
+	stored.Variants = variants
+	delete(r.owners, img.ID)
+	delete(r.leases, img.ID)
+	r.processed[lease.JobID] = true
+	return nil
+}
+
+func (r *fakeRepo) EnqueueImage(ctx context.Context, id int, from []model.Status, msg *model.OutboxMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	img, ok := r.images[id]
+	if !ok {
+		return model.ErrImageNotFound
+	}
+	if !slices.Contains(from, img.Status) {
+		return model.ErrStatusConflict
+	}
+	img.Status = model.StatusEnqueued
+	r.outbox = append(r.outbox, msg)
+	return nil
+}
+
+func (r *fakeRepo) PublishOutbox(ctx context.Context, limit int, publish func(msg *model.OutboxMessage) error) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for r.published < len(r.outbox) && n < limit {
+		err := publish(r.outbox[r.published])
+		if err != nil {
+			return n, err
+		}
+		r.published++
+		n++
+	}
+	return n, nil
+}
+
+func (r *fakeRepo) PurgeOutbox(ctx context.Context, olderThan time.Duration) (int64, error) {
+	return 0, nil
+}
+
+func (r *fakeRepo) ListImages(ctx context.Context, q *model.ImageQuery) (*model.ImagePage, error) {
+	return nil, errNotImplemented
+}
+
+func (r *fakeRepo) GetImageVariants(ctx context.Context, imageID int) ([]*model.ImageVariant, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	img, ok := r.images[imageID]
+	if !ok {
+		return nil, model.ErrImageNotFound
+	}
+	return img.Variants, nil
+}
+
+func (r *fakeRepo) AcquireBlob(ctx context.Context, hash, path string, size int64) error {
+	return errNotImplemented
+}
+
+func (r *fakeRepo) ReleaseBlob(ctx context.Context, hash string, remove func(path string) error) error {
+	return errNotImplemented
+}
+
+// nopCache - кэш производных изображений, в котором ничего нет
+type nopCache struct{}
+
+func (nopCache) Get(key string) (*os.File, os.FileInfo, error) {
+	return nil, nil, os.ErrNotExist
+}
+
+func (nopCache) Put(key string, data []byte) error {
+	return nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/Vladimirmoscow84/Image_processor/internal/pipeline"
+	"github.com/Vladimirmoscow84/Image_processor/internal/storage/blob"
+)
+
+// VariantOriginal - имя, под которым отдается оригинал изображения
+const VariantOriginal = "original"
+
+// OpenImageFile открывает на чтение оригинал или сохраненный вариант изображения;
+// вариант отдается в формате из accept, если так он получается меньше
+func (s *Service) OpenImageFile(ctx context.Context, id int, variant string, accept []string) (io.ReadSeekCloser, *blob.ObjectInfo, error) {
+	img, err := s.GetImage(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := variantKey(img, variant)
+	if key == "" {
+		return nil, nil, fmt.Errorf("[imageprocessor] variant %q of image %d: %w", variant, id, blob.ErrNotFound)
+	}
+	r, info, err := s.store.Get(ctx, key)
+	if err != nil || variant == VariantOriginal {
+		return r, info, err
+	}
+	return s.negotiate(ctx, r, info, accept)
+}
+
+// variantKey возвращает ключ хранения варианта изображения или пустую строку
+func variantKey(img *model.Image, variant string) string {
+	if variant == VariantOriginal {
+		return img.OriginalPath
+	}
+	for _, v := range img.Variants {
+		if v.Name == variant {
+			return v.Path
+		}
+	}
+	// записи, обработанные до появления таблицы вариантов
+	switch variant {
+	case pipeline.VariantProcessed:
+		return img.ProcessedPath
+	case pipeline.VariantThumb:
+		return img.ThumbnailPath
+	}
+	return ""
+}
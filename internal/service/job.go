@@ -0,0 +1,66 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/google/uuid"
+)
+
+var ErrUnsupportedJobVersion = errors.New("unsupported job version")
+
+// newJob создает задание текущей версии для изображения
+func newJob(imageID int, opts *model.ProcessingOptions) *model.Job {
+	return &model.Job{
+		Version:    model.JobVersion,
+		ID:         uuid.NewString(),
+		ImageID:    imageID,
+		Options:    opts,
+		Attempt:    1,
+		TraceID:    uuid.NewString(),
+		EnqueuedAt: time.Now().UTC(),
+	}
+}
+
+// encodeJob сериализует задание для отправки в очередь
+func encodeJob(job *model.Job) ([]byte, error) {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return nil, fmt.Errorf("[job] failed to encode job: %w", err)
+	}
+	return data, nil
+}
+
+// decodeJob разбирает сообщение очереди и приводит старые форматы к текущей версии.
+// Версия 0 - голый ID изображения либо JSON без поля version
+func decodeJob(data []byte) (*model.Job, error) {
+	if id, err := strconv.Atoi(string(data)); err == nil {
+		return newJob(id, nil), nil
+	}
+
+	job := &model.Job{}
+	err := json.Unmarshal(data, job)
+	if err != nil {
+		return nil, fmt.Errorf("[job] failed to decode job: %w", err)
+	}
+
+	switch job.Version {
+	case 0:
+		job = newJob(job.ImageID, job.Options)
+	case model.JobVersion:
+		if job.ID == "" {
+			return nil, fmt.Errorf("[job] job_id is required")
+		}
+	default:
+		return nil, fmt.Errorf("[job] %w: %d", ErrUnsupportedJobVersion, job.Version)
+	}
+
+	if job.ImageID <= 0 {
+		return nil, fmt.Errorf("[job] invalid image_id: %d", job.ImageID)
+	}
+	return job, nil
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
+	"github.com/google/uuid"
+)
+
+// DefaultLeaseTTL - время аренды изображения воркером по умолчанию; аренда продлевается,
+// пока обработка идет, и истекает, только если воркер перестал отвечать
+const DefaultLeaseTTL = 2 * time.Minute
+
+// postponeDelay - через сколько повторить задание, изображение которого занято другим воркером.
+// Интервал короткий: владелец аренды обычно скоро закончит, а ожидание до конца аренды
+// задерживало бы повторы в очереди
+const postponeDelay = 10 * time.Second
+
+// workerName возвращает имя процесса для владельца аренды: хост и PID
+func workerName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	return host + "-" + strconv.Itoa(os.Getpid())
+}
+
+// newLease создает аренду для задания; владелец уникален для каждой доставки,
+// поэтому две копии одного задания не считают чужую аренду своей
+func (s *Service) newLease(job *model.Job) *model.Lease {
+	return &model.Lease{
+		Owner: s.worker + "/" + uuid.NewString(),
+		JobID: job.ID,
+		TTL:   s.leaseTTL,
+	}
+}
+
+// claimableFrom возвращает статусы, из которых изображение можно захватить в обработку.
+// Из processing захват возможен только после истечения чужой аренды, это проверяет ClaimImage
+func claimableFrom() []model.Status {
+	var from []model.Status
+	for _, st := range allowedFrom(model.StatusProcessing) {
+		if st != model.StatusProcessing {
+			from = append(from, st)
+		}
+	}
+	return from
+}
+
+// keepLease продлевает аренду каждые треть TTL, пока не вызвана stop. Если аренда потеряна,
+// возвращенный контекст отменяется с причиной model.ErrLeaseLost
+func (s *Service) keepLease(ctx context.Context, id int, lease *model.Lease) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(lease.TTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := s.db.RenewLease(ctx, id, lease)
+				if errors.Is(err, model.ErrLeaseLost) {
+					cancel(err)
+					return
+				}
+				if err != nil {
+					s.log.WarnContext(ctx, "failed to renew lease", logger.Err(err))
+				}
+			}
+		}
+	}()
+
+	return ctx, func() {
+		close(done)
+		cancel(nil)
+	}
+}
+
+// postponeJob откладывает задание на postponeDelay, не расходуя попытку. Задержку выдерживает
+// очередь, поэтому воркер сразу освобождается; если владелец аренды завершит обработку,
+// отложенная доставка будет пропущена по processed_jobs, а если нет - задание отложится снова
+func (s *Service) postponeJob(ctx context.Context, job *model.Job) error {
+	msg, err := encodeJob(job)
+	if err != nil {
+		return err
+	}
+	notBefore := time.Now().Add(postponeDelay)
+	err = s.queue.ProduceRetry(ctx, strconv.Itoa(job.ImageID), msg, notBefore)
+	if err != nil {
+		return fmt.Errorf("[worker] failed to postpone job %s: %w", job.ID, err)
+	}
+	s.log.InfoContext(ctx, "job postponed", "not_before", notBefore)
+	return nil
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/pipeline"
+	"github.com/Vladimirmoscow84/Image_processor/internal/storage/blob"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
+	"github.com/disintegration/imaging"
+)
+
+// negotiate подменяет файл версией в формате из accept, если она меньше исходной;
+// перекодированные версии хранятся в кэше производных изображений. Файлы, сжатые с потерями,
+// отдаются как есть (см. pipeline.Lossy)
+func (s *Service) negotiate(ctx context.Context, r io.ReadSeekCloser, info *blob.ObjectInfo, accept []string) (io.ReadSeekCloser, *blob.ObjectInfo, error) {
+	src := strings.ToLower(path.Ext(info.Key))
+	if len(accept) == 0 || pipeline.Lossy(src) || slices.Contains(accept, src) {
+		return r, info, nil
+	}
+
+	for _, ext := range accept {
+		f, fInfo, err := s.reencode(r, info, ext)
+		if err != nil {
+			s.log.WarnContext(ctx, "failed to re-encode", "key", info.Key, "format", ext, logger.Err(err))
+			continue
+		}
+		if fInfo.Size < info.Size {
+			r.Close()
+			return f, fInfo, nil
+		}
+		f.Close()
+	}
+
+	_, err := r.Seek(0, io.SeekStart)
+	if err != nil {
+		r.Close()
+		return nil, nil, fmt.Errorf("[imageprocessor] failed to rewind %s: %w", info.Key, err)
+	}
+	return r, info, nil
+}
+
+// reencode возвращает версию файла в формате ext из кэша, при отсутствии строит ее
+func (s *Service) reencode(r io.ReadSeeker, info *blob.ObjectInfo, ext string) (io.ReadSeekCloser, *blob.ObjectInfo, error) {
+	sum := sha256.Sum256([]byte(info.Key + "|" + info.ETag + "|" + ext))
+	etag := hex.EncodeToString(sum[:])
+	key := etag + ext
+
+	f, fi, err := s.openDerived(key, func() error {
+		_, err := r.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		img, err := imaging.Decode(r, imaging.AutoOrientation(true))
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		err = pipeline.Encode(&buf, img, ext, pipeline.DefaultQuality)
+		if err != nil {
+			return err
+		}
+		return s.derived.Put(key, buf.Bytes())
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return f, &blob.ObjectInfo{
+		Key:         key,
+		Size:        fi.Size(),
+		ContentType: pipeline.ContentType(ext),
+		ETag:        etag,
+		ModTime:     fi.ModTime(),
+	}, nil
+}
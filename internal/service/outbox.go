@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/Vladimirmoscow84/Image_processor/internal/tracing"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	outboxPurgeInterval = time.Hour
+	outboxRetention     = 24 * time.Hour
+)
+
+// jobMessage строит сообщение outbox с заданием на обработку изображения; контекст трассировки
+// из ctx сохраняется в заголовках, и обработка задания продолжает трассу загрузки. ID запроса
+// переходит в задание, чтобы записи лога воркера находились по тому же request_id
+func (s *Service) jobMessage(ctx context.Context, imageID int, opts *model.ProcessingOptions) (*model.OutboxMessage, error) {
+	job := newJob(imageID, opts)
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		job.TraceID = sc.TraceID().String()
+	}
+	job.RequestID = logger.RequestID(ctx)
+	payload, err := encodeJob(job)
+	if err != nil {
+		return nil, err
+	}
+	ctx = logger.WithJobID(logger.WithImageID(ctx, imageID), job.ID)
+	s.log.InfoContext(ctx, "job written to outbox")
+	return &model.OutboxMessage{Key: strconv.Itoa(imageID), Payload: payload, Headers: tracing.Inject(ctx)}, nil
+}
+
+// StartOutboxRelay запускает фоновую отправку заданий из outbox в очередь. Сообщение помечается
+// отправленным только после подтверждения очереди, поэтому каждое задание доставляется хотя бы один раз.
+// Возвращаемый канал закрывается, когда после отмены ctx ретранслятор перестал обращаться к базе и очереди
+func (s *Service) StartOutboxRelay(ctx context.Context, interval time.Duration, batchSize int) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		purge := time.NewTicker(outboxPurgeInterval)
+		defer purge.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				s.log.Info("outbox relay stopped")
+				return
+			case <-purge.C:
+				n, err := s.db.PurgeOutbox(ctx, outboxRetention)
+				if err != nil {
+					s.log.Error("outbox purge failed", logger.Err(err))
+				} else if n > 0 {
+					s.log.Info("outbox purged", "messages", n)
+				}
+				continue
+			case <-ticker.C:
+			}
+
+			// полный пакет означает, что в outbox могут оставаться сообщения
+			for ctx.Err() == nil {
+				n, err := s.db.PublishOutbox(ctx, batchSize, func(msg *model.OutboxMessage) error {
+					return s.queue.Produce(tracing.Extract(ctx, msg.Headers), msg.Key, msg.Payload)
+				})
+				if err != nil {
+					s.log.Error("outbox relay error", logger.Err(err))
+					break
+				}
+				if n < batchSize {
+					break
+				}
+			}
+		}
+	}()
+	s.log.Info("outbox relay started", "poll_interval", interval)
+	return done
+}
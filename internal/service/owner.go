@@ -0,0 +1,18 @@
+package service
+
+import (
+	"context"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/auth"
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+)
+
+// ownerScope возвращает область видимости пользователя запроса. Вызов без пользователя
+// отклоняется: воркер и другие внутренние вызовы обращаются к хранилищу с model.AllOwners
+func ownerScope(ctx context.Context) (model.OwnerScope, error) {
+	p, ok := auth.FromContext(ctx)
+	if !ok {
+		return model.OwnerScope{}, model.ErrUnauthenticated
+	}
+	return p.Scope(), nil
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy - параметры повторной обработки упавших заданий
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy - 5 попыток с задержкой от 1 секунды до 1 минуты
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   time.Second,
+		MaxDelay:    time.Minute,
+	}
+}
+
+// backoff возвращает экспоненциальную задержку перед попыткой attempt с разбросом ±20%
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	jitter := time.Duration(rand.Int64N(int64(delay)/5*2+1)) - delay/5
+	return delay + jitter
+}
@@ -3,51 +3,105 @@ package service
 import (
 	"context"
 	"errors"
-	"image"
-	"log"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/Vladimirmoscow84/Image_processor/internal/pipeline"
+	queuebroker "github.com/Vladimirmoscow84/Image_processor/internal/queue_broker"
+	"github.com/Vladimirmoscow84/Image_processor/internal/storage/blob"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
+	"golang.org/x/sync/singleflight"
 )
 
 type imageProcessorRepo interface {
-	AddImage(ctx context.Context, image *model.Image) (int, error)
-	GetImage(ctx context.Context, id int) (*model.Image, error)
-	DeleteImage(ctx context.Context, id int) error
-	UpdateImage(ctx context.Context, image *model.Image) error
-	GetAllImages(ctx context.Context) ([]*model.Image, error)
+	AddImage(ctx context.Context, image *model.Image, outbox func(id int) (*model.OutboxMessage, error)) (int, bool, error)
+	GetImage(ctx context.Context, id int, scope model.OwnerScope) (*model.Image, error)
+	GetImageByOriginalPath(ctx context.Context, originalPath, ownerID string, opts *model.ProcessingOptions) (*model.Image, error)
+	DeleteImage(ctx context.Context, id int, scope model.OwnerScope) error
+	UpdateImageStatus(ctx context.Context, id int, from []model.Status, to model.Status, errMsg string) error
+	IsJobProcessed(ctx context.Context, jobID string) (bool, error)
+	ClaimImage(ctx context.Context, id int, from []model.Status, lease *model.Lease) error
+	RenewLease(ctx context.Context, id int, lease *model.Lease) error
+	ReleaseImage(ctx context.Context, id int, lease *model.Lease) error
+	CompleteImage(ctx context.Context, img *model.Image, variants []*model.ImageVariant, lease *model.Lease) error
+	EnqueueImage(ctx context.Context, id int, from []model.Status, msg *model.OutboxMessage) error
+	PublishOutbox(ctx context.Context, limit int, publish func(msg *model.OutboxMessage) error) (int, error)
+	PurgeOutbox(ctx context.Context, olderThan time.Duration) (int64, error)
+	ListImages(ctx context.Context, q *model.ImageQuery) (*model.ImagePage, error)
+	GetImageVariants(ctx context.Context, imageID int) ([]*model.ImageVariant, error)
+	AcquireBlob(ctx context.Context, hash, path string, size int64) error
+	ReleaseBlob(ctx context.Context, hash string, remove func(path string) error) error
 }
 
-type fileStorageRepo interface {
-	Save(ctx context.Context, origPath string) (string, error)
-	SaveImage(ctx context.Context, img image.Image, destPath string) (string, error)
-	Delete(ctx context.Context, destPath string) error
-}
-
-type kafkaProducerConsumer interface {
-	Produce(ctx context.Context, msg string) error
-	Consume(ctx context.Context, handler func(string) error) error
-	Close() error
+type derivedCache interface {
+	Get(key string) (*os.File, os.FileInfo, error)
+	Put(key string, data []byte) error
 }
 
 type Service struct {
-	db    imageProcessorRepo
-	fs    fileStorageRepo
-	kafka kafkaProducerConsumer
+	db       imageProcessorRepo
+	store    blob.Store
+	queue    queuebroker.Queue
+	pipeline *pipeline.Pipeline
+	retry    RetryPolicy
+	leaseTTL time.Duration
+	worker   string
+	log      *slog.Logger
+
+	derived   derivedCache
+	transform pipeline.TransformConfig
+	renders   singleflight.Group
+
+	limits UploadLimits
+
+	draining     atomic.Bool
+	jobs         context.Context
+	cancelJobs   context.CancelFunc
+	consumerDone chan struct{}
 }
 
-func New(db imageProcessorRepo, fs fileStorageRepo, kafka kafkaProducerConsumer) (*Service, error) {
+func New(db imageProcessorRepo, store blob.Store, queue queuebroker.Queue, p *pipeline.Pipeline, retry RetryPolicy, derived derivedCache, transform pipeline.TransformConfig, limits UploadLimits, leaseTTL time.Duration, log *slog.Logger) (*Service, error) {
 	if db == nil {
 		return nil, errors.New("[service] db client is nil")
 	}
-	if fs == nil {
-		return nil, errors.New("[service] file storage client is nil")
+	if store == nil {
+		return nil, errors.New("[service] blob storage client is nil")
+	}
+	if derived == nil {
+		return nil, errors.New("[service] derived image cache is nil")
 	}
-	if kafka == nil {
-		log.Println("[service] kafka client is nil, service will be work without queue")
+	if queue == nil {
+		return nil, errors.New("[service] job queue is nil")
 	}
+	if p == nil {
+		p = pipeline.Default()
+	}
+	if retry.MaxAttempts <= 0 {
+		retry = DefaultRetryPolicy()
+	}
+	if leaseTTL <= 0 {
+		leaseTTL = DefaultLeaseTTL
+	}
+	jobs, cancelJobs := context.WithCancel(context.Background())
 	return &Service{
-		db:    db,
-		fs:    fs,
-		kafka: kafka,
+		db:       db,
+		store:    store,
+		queue:    queue,
+		pipeline: p,
+		retry:    retry,
+		leaseTTL: leaseTTL,
+		worker:   workerName(),
+		log:      logger.OrDefault(log).With("component", "service"),
+
+		derived:   derived,
+		transform: transform,
+
+		limits: limits,
+
+		jobs:       jobs,
+		cancelJobs: cancelJobs,
 	}, nil
 }
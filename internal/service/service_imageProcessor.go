@@ -1,171 +1,458 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"path/filepath"
+	"image"
+	"io"
+	"path"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Vladimirmoscow84/Image_processor/internal/metrics"
 	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/Vladimirmoscow84/Image_processor/internal/pipeline"
+	"github.com/Vladimirmoscow84/Image_processor/internal/storage/blob"
+	"github.com/Vladimirmoscow84/Image_processor/internal/tracing"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
 	"github.com/disintegration/imaging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
 )
 
 type ImageProcessorService interface {
-	ProcessAndSaveImage(ctx context.Context, origPath string) (*model.Image, error)
+	ProcessAndSaveImage(ctx context.Context, img *model.Image, opts *model.ProcessingOptions, lease *model.Lease) (*model.Image, error)
 	DeleteImage(ctx context.Context, image *model.Image) error
-	EnqueueImage(ctx context.Context, origPath string) error
-	StartKafkaConsumer(ctx context.Context)
+	EnqueueImage(ctx context.Context, imageID int, opts *model.ProcessingOptions) error
+	StartConsumer(ctx context.Context)
 }
 
-// ProcessAndSaveImage обрабатывает изображение и сохраняет все версии (original, processed, thumbnail)
-// возвращает объект модели с заполненными путями и статусом
-func (s *Service) ProcessAndSaveImage(ctx context.Context, origPath string) (*model.Image, error) {
+// ProcessAndSaveImage строит все варианты конвейера для изображения из задания
+// и сохраняет пути к ним и статус processed в его записи. Ключи вариантов детерминированы,
+// а хранилище записывает объект целиком (временный файл и rename), поэтому повторная
+// обработка лишь перезаписывает те же файлы; результат сохраняется, только пока действует lease
+func (s *Service) ProcessAndSaveImage(ctx context.Context, img *model.Image, opts *model.ProcessingOptions, lease *model.Lease) (*model.Image, error) {
 
-	path, err := s.fs.Save(ctx, origPath)
+	p, err := s.pipeline.WithOptions(opts)
 	if err != nil {
-		return nil, fmt.Errorf("[imageprocessor] failed to save original: %w", err)
+		return nil, fmt.Errorf("[imageprocessor] invalid processing options: %w", err)
 	}
 
-	processedPathSaved, thumbPathSaved, err := s.createProcessedVersions(ctx, path)
+	variants, err := s.createProcessedVersions(ctx, img, p)
 	if err != nil {
-		return nil, fmt.Errorf("[imageprocessor] failed to create processed/thumb: %w", err)
+		s.discardOrphans(ctx, img.ID, variants, err)
+		return nil, fmt.Errorf("[imageprocessor] failed to create variants: %w", err)
 	}
 
-	var img *model.Image
-	images, _ := s.db.GetAllImages(ctx)
-	for _, im := range images {
-		if im.OriginalPath == path {
-			img = im
-			break
-		}
+	setVariantPaths(img, variants)
+	err = s.db.CompleteImage(ctx, img, variants, lease)
+	if err != nil {
+		s.discardOrphans(ctx, img.ID, variants, err)
+		return nil, fmt.Errorf("[imageprocessor] failed to save processing result: %w", err)
 	}
+	img.Status = model.StatusProcessed
+	img.Variants = variants
 
-	if img == nil {
-		img = &model.Image{
-			OriginalPath:  path,
-			ProcessedPath: processedPathSaved,
-			ThumbnailPath: thumbPathSaved,
-			Status:        "processed",
-		}
-		id, err := s.db.AddImage(ctx, img)
+	return img, nil
+}
+
+// createProcessedVersions строит все варианты из конвейера и сохраняет их под префиксом по имени варианта.
+// Ключ варианта содержит ID изображения: у изображений с общим оригиналом могут быть разные параметры.
+// При ошибке возвращаются и варианты, уже записанные в хранилище
+func (s *Service) createProcessedVersions(ctx context.Context, image *model.Image, p *pipeline.Pipeline) ([]*model.ImageVariant, error) {
+	img, exif, err := s.openImage(ctx, image.OriginalPath)
+	if err != nil {
+		return nil, err
+	}
+
+	origPath := image.OriginalPath
+	base := strings.TrimSuffix(path.Base(origPath), path.Ext(origPath))
+	shard := path.Join(base[:min(2, len(base))], base[min(2, len(base)):min(4, len(base))])
+	variants := make([]*model.ImageVariant, 0, len(p.Variants))
+	for _, v := range p.Variants {
+		ext := v.Ext(origPath)
+		key := path.Join(v.Name, shard, fmt.Sprintf("%s-%d%s", base, image.ID, ext))
+		variant, err := s.createVariant(ctx, p, v, img, exif, key, ext)
 		if err != nil {
-			return nil, fmt.Errorf("[imageprocessor] failed to add image record: %w", err)
+			return variants, err
 		}
-		img.ID = id
-	} else {
-		img.ProcessedPath = processedPathSaved
-		img.ThumbnailPath = thumbPathSaved
-		img.Status = "processed"
-		err = s.db.UpdateImage(ctx, img)
+		variants = append(variants, variant)
+	}
+
+	return variants, nil
+}
+
+// createVariant строит, кодирует и сохраняет один вариант под ключом key
+func (s *Service) createVariant(ctx context.Context, p *pipeline.Pipeline, v pipeline.Variant, img image.Image, exif []byte, key, ext string) (_ *model.ImageVariant, err error) {
+	ctx, span := tracer.Start(ctx, "service.createVariant", trace.WithAttributes(attribute.String("variant", v.Name)))
+	defer func() { tracing.End(span, err) }()
+
+	start := time.Now()
+	out := p.Render(v, img)
+
+	var buf bytes.Buffer
+	err = pipeline.Encode(&buf, out, ext, v.OutputQuality())
+	if err != nil {
+		return nil, fmt.Errorf("[imageprocessor] failed to encode variant %s: %w", v.Name, err)
+	}
+	data := pipeline.EmbedEXIF(buf.Bytes(), ext, exif)
+	err = s.store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), pipeline.ContentType(ext))
+	if err != nil {
+		return nil, fmt.Errorf("[imageprocessor] failed to save variant %s: %w", v.Name, err)
+	}
+	metrics.ObserveVariant(v.Name, time.Since(start), len(data))
+	return &model.ImageVariant{
+		Name:   v.Name,
+		Path:   key,
+		Width:  out.Bounds().Dx(),
+		Height: out.Bounds().Dy(),
+	}, nil
+}
+
+// discardOrphans удаляет варианты, записанные заданием, которое потеряло аренду из-за удаления
+// изображения: DeleteImage удаляет только варианты из БД и записанные воркером файлы не увидит.
+// Если аренду забрал другой воркер или изображение поставлено в очередь заново, файлы не трогаются:
+// ключи вариантов у новой обработки те же
+func (s *Service) discardOrphans(ctx context.Context, id int, variants []*model.ImageVariant, cause error) {
+	if len(variants) == 0 || !errors.Is(cause, model.ErrLeaseLost) && !errors.Is(context.Cause(ctx), model.ErrLeaseLost) {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+
+	img, err := s.db.GetImage(ctx, id, model.AllOwners)
+	switch {
+	case errors.Is(err, model.ErrImageNotFound):
+	case err != nil:
+		s.log.WarnContext(ctx, "failed to check image before discarding variants", logger.Err(err))
+		return
+	case img.Status != model.StatusDeleting:
+		return
+	}
+
+	for _, v := range variants {
+		err := s.deleteFile(ctx, v.Path)
 		if err != nil {
-			return nil, fmt.Errorf("[imageprocessor] failed to update image record: %w", err)
+			s.log.WarnContext(ctx, "failed to discard variant of deleted image", "key", v.Path, logger.Err(err))
 		}
 	}
-
-	return img, nil
+	s.log.InfoContext(ctx, "image deleted during processing, variants discarded", "variants", len(variants))
 }
 
-// createProcessedVersions создаёт processed и thumbnail версии изображения
-func (s *Service) createProcessedVersions(ctx context.Context, origPath string) (string, string, error) {
-	img, err := imaging.Open(origPath)
+// openImage читает и декодирует изображение из хранилища с поворотом по EXIF-ориентации;
+// вместе с изображением возвращается EXIF для производных версий согласно политике конвейера
+func (s *Service) openImage(ctx context.Context, key string) (image.Image, []byte, error) {
+	r, _, err := s.store.Get(ctx, key)
 	if err != nil {
-		return "", "", fmt.Errorf("[imageprocessor] failed to open image: %w", err)
+		return nil, nil, fmt.Errorf("[imageprocessor] failed to open image: %w", err)
 	}
+	defer r.Close()
 
-	processedImg := imaging.Resize(img, 1280, 0, imaging.Lanczos)
-	processedPath := filepath.Join("processed", filepath.Base(origPath))
-	processedSaved, err := s.fs.SaveImage(ctx, processedImg, processedPath)
+	raw, err := pipeline.ReadEXIF(r)
+	if err != nil {
+		s.log.WarnContext(ctx, "failed to read exif", "key", key, logger.Err(err))
+	}
+	_, err = r.Seek(0, io.SeekStart)
 	if err != nil {
-		return "", "", fmt.Errorf("[imageprocessor] failed to save processed image: %w", err)
+		return nil, nil, fmt.Errorf("[imageprocessor] failed to rewind image: %w", err)
 	}
 
-	thumbImg := imaging.Resize(img, 300, 0, imaging.Lanczos)
-	thumbPath := filepath.Join("thumbs", filepath.Base(origPath))
-	thumbSaved, err := s.fs.SaveImage(ctx, thumbImg, thumbPath)
+	img, err := imaging.Decode(r, imaging.AutoOrientation(true))
 	if err != nil {
-		return "", "", fmt.Errorf("[imageprocessor] failed to save thumbnail: %w", err)
+		return nil, nil, fmt.Errorf("[imageprocessor] failed to decode image: %w", err)
 	}
+	return img, s.pipeline.OutputEXIF(raw), nil
+}
 
-	return processedSaved, thumbSaved, nil
+// setVariantPaths переносит пути основных вариантов в поля processed_path и thumbnail_path
+func setVariantPaths(img *model.Image, variants []*model.ImageVariant) {
+	for _, v := range variants {
+		switch v.Name {
+		case pipeline.VariantProcessed:
+			img.ProcessedPath = v.Path
+		case pipeline.VariantThumb:
+			img.ThumbnailPath = v.Path
+		}
+	}
 }
 
-// DeleteImage удаляет все версии изображения и запись из БД
-func (s *Service) DeleteImage(ctx context.Context, image *model.Image) error {
-	if err := s.fs.Delete(ctx, image.OriginalPath); err != nil {
-		return fmt.Errorf("[imageprocessor] failed to delete original: %w", err)
+// DeleteImage переводит изображение в статус deleting и удаляет варианты, ссылку на оригинал и запись из БД.
+// Если удаление прервется, повторный вызов продолжит его с того же статуса
+func (s *Service) DeleteImage(ctx context.Context, image *model.Image) (err error) {
+	ctx, span := tracer.Start(ctx, "service.DeleteImage", trace.WithAttributes(attribute.Int("image.id", image.ID)))
+	defer func() { tracing.End(span, err) }()
+
+	scope, err := ownerScope(ctx)
+	if err != nil {
+		return err
 	}
-	if err := s.fs.Delete(ctx, image.ProcessedPath); err != nil {
-		return fmt.Errorf("[imageprocessor] failed to delete processed: %w", err)
+	if !scope.Allows(image.OwnerID) {
+		return fmt.Errorf("[imageprocessor] image %d: %w", image.ID, model.ErrImageNotFound)
 	}
-	if err := s.fs.Delete(ctx, image.ThumbnailPath); err != nil {
-		return fmt.Errorf("[imageprocessor] failed to delete thumbnail: %w", err)
+
+	if err := s.setStatus(ctx, image.ID, model.StatusDeleting, ""); err != nil {
+		return fmt.Errorf("[imageprocessor] failed to mark image as deleting: %w", err)
 	}
-	if err := s.db.DeleteImage(ctx, image.ID); err != nil {
+
+	variants := image.Variants
+	if len(variants) == 0 {
+		// записи, обработанные до появления таблицы вариантов
+		variants = []*model.ImageVariant{
+			{Name: pipeline.VariantProcessed, Path: image.ProcessedPath},
+			{Name: pipeline.VariantThumb, Path: image.ThumbnailPath},
+		}
+	}
+	for _, v := range variants {
+		if err := s.deleteFile(ctx, v.Path); err != nil {
+			return fmt.Errorf("[imageprocessor] failed to delete variant %s: %w", v.Name, err)
+		}
+	}
+
+	if image.ContentHash == "" {
+		// записи, загруженные до хранения по хешу содержимого
+		if err := s.deleteFile(ctx, image.OriginalPath); err != nil {
+			return fmt.Errorf("[imageprocessor] failed to delete original: %w", err)
+		}
+	}
+
+	if err := s.db.DeleteImage(ctx, image.ID, scope); err != nil {
 		return fmt.Errorf("[imageprocessor] failed to delete DB record: %w", err)
 	}
+
+	// ссылка снимается после удаления записи, чтобы повторное удаление не сняло ее дважды;
+	// оригинал удаляется только вместе с последней ссылкой на него
+	if image.ContentHash != "" {
+		s.releaseOriginal(ctx, image.ContentHash)
+	}
 	return nil
 }
 
-// EnqueueImage отправляет ID изображения в Kafka
-func (s *Service) EnqueueImage(ctx context.Context, imageID int) error {
-	if s.kafka == nil {
-		return fmt.Errorf("[imageprocessor] kafka client is nil")
+// deleteFile удаляет файл изображения из хранилища. Пути старых записей вне хранилища
+// (абсолютные, с "..") не удаляются: запись удаляется, файл остается на месте
+func (s *Service) deleteFile(ctx context.Context, key string) error {
+	err := s.store.Delete(ctx, key)
+	if errors.Is(err, blob.ErrInvalidKey) {
+		s.log.WarnContext(ctx, "skipping delete of path outside storage", logger.Err(err))
+		return nil
 	}
-	return s.kafka.Produce(ctx, strconv.Itoa(imageID))
+	return err
 }
 
-// StartKafkaConsumer запускает фоновый воркер для обработки очереди
-func (s *Service) StartKafkaConsumer(ctx context.Context) {
-	if s.kafka == nil {
-		log.Println("[imageprocessor] kafka is nil, consumer not ready")
-		return
+// ValidateOptions проверяет параметры обработки относительно настроенного конвейера
+func (s *Service) ValidateOptions(opts *model.ProcessingOptions) error {
+	_, err := s.pipeline.WithOptions(opts)
+	return err
+}
+
+// EnqueueImage переводит изображение в статус enqueued и в той же транзакции записывает
+// задание в outbox; в очередь его отправляет StartOutboxRelay
+func (s *Service) EnqueueImage(ctx context.Context, imageID int, opts *model.ProcessingOptions) (err error) {
+	ctx, span := tracer.Start(ctx, "service.EnqueueImage", trace.WithAttributes(attribute.Int("image.id", imageID)))
+	defer func() { tracing.End(span, err) }()
+
+	msg, err := s.jobMessage(ctx, imageID, opts)
+	if err != nil {
+		return err
+	}
+	err = s.db.EnqueueImage(ctx, imageID, allowedFrom(model.StatusEnqueued), msg)
+	if err != nil {
+		return fmt.Errorf("[imageprocessor] failed to enqueue image %d: %w", imageID, err)
 	}
+	metrics.JobEnqueued()
+	return nil
+}
 
+// StartConsumer запускает фоновый воркер для обработки очереди. Отмена ctx останавливает
+// чтение новых сообщений; завершения начатых заданий ждет WaitJobs
+func (s *Service) StartConsumer(ctx context.Context) {
+	s.consumerDone = make(chan struct{})
 	go func() {
-		err := s.kafka.Consume(ctx, func(msg string) error {
-			id, err := strconv.Atoi(msg)
-			if err != nil {
-				log.Printf("[worker] invalid image ID: %s", msg)
-				return nil
-			}
-
-			img, err := s.db.GetImage(ctx, id)
-			if err != nil {
-				log.Printf("[worker] image with id=%d not found", id)
-				return nil
-			}
-
-			_, err = s.ProcessAndSaveImage(ctx, img.OriginalPath)
-			if err != nil {
-				log.Printf("[worker] failed to process %d: %v", id, err)
-			} else {
-				log.Printf("[worker] successfully processed %d", id)
-			}
-			return nil
-		})
+		defer close(s.consumerDone)
+		err := s.queue.Consume(ctx, s.handleJob)
 		if err != nil && ctx.Err() == nil {
-			log.Printf("[worker] consumer error: %v", err)
+			s.log.Error("consumer error", logger.Err(err))
 		}
 	}()
 }
 
-// GetImage возвращает изображение по ID
-func (s *Service) GetImage(ctx context.Context, id int) (*model.Image, error) {
-	return s.db.GetImage(ctx, id)
+// handleJob обрабатывает одно сообщение очереди. Ошибка возвращается только если
+// задание не удалось переотправить - тогда сообщение не подтверждается и придет снова
+func (s *Service) handleJob(ctx context.Context, msg []byte) (err error) {
+	ctx, span := tracer.Start(ctx, "service.handleJob")
+	defer func() { tracing.End(span, err) }()
+
+	job, err := decodeJob(msg)
+	if err != nil {
+		// задание невозможно разобрать - повторная доставка не поможет
+		s.log.ErrorContext(ctx, "rejected job", "payload", string(msg), logger.Err(err))
+		return s.queue.ProduceDeadLetter(ctx, "", msg)
+	}
+	id := job.ImageID
+	ctx = logger.WithJobID(logger.WithImageID(ctx, id), job.ID)
+	if job.RequestID != "" {
+		ctx = logger.WithRequestID(ctx, job.RequestID)
+	}
+	span.SetAttributes(
+		attribute.String("job.id", job.ID),
+		attribute.Int("job.attempt", job.Attempt),
+		attribute.Int("image.id", id),
+	)
+
+	ctx, cancel := s.jobContext(ctx)
+	defer cancel()
+
+	// задание, уже выполненное при прошлой доставке, не обрабатывается повторно
+	done, err := s.db.IsJobProcessed(ctx, job.ID)
+	if err != nil {
+		return fmt.Errorf("[worker] job %s: failed to check idempotency key: %w", job.ID, err)
+	}
+	if done {
+		s.log.InfoContext(ctx, "duplicate delivery, already processed")
+		return nil
+	}
+
+	img, err := s.db.GetImage(ctx, id, model.AllOwners)
+	if errors.Is(err, model.ErrImageNotFound) {
+		// изображение удалено - обрабатывать нечего
+		s.log.WarnContext(ctx, "image not found", logger.Err(err))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("[worker] job %s: failed to get image %d: %w", job.ID, id, err)
+	}
+
+	lease := s.newLease(job)
+	err = s.db.ClaimImage(ctx, id, claimableFrom(), lease)
+	switch {
+	case errors.Is(err, model.ErrImageLeased):
+		s.log.InfoContext(ctx, "image is being processed by another worker")
+		return s.postponeJob(ctx, job)
+	case errors.Is(err, model.ErrStatusConflict):
+		s.log.InfoContext(ctx, "image is not claimable, skipping", "status", img.Status)
+		return nil
+	case err != nil:
+		return fmt.Errorf("[worker] job %s: failed to claim image %d: %w", job.ID, id, err)
+	}
+
+	opts := job.Options
+	if opts == nil {
+		opts = img.Options
+	}
+
+	leaseCtx, stop := s.keepLease(ctx, id, lease)
+	_, err = s.ProcessAndSaveImage(leaseCtx, img, opts, lease)
+	stop()
+	if errors.Is(err, model.ErrLeaseLost) || errors.Is(context.Cause(leaseCtx), model.ErrLeaseLost) {
+		// аренду забрал другой воркер или изображение удалено; записанные файлы удаленного
+		// изображения уже убрал discardOrphans
+		s.log.WarnContext(ctx, "lease lost, result discarded")
+		return nil
+	}
+	if err != nil && ctx.Err() != nil {
+		// сервис завершается: изображение возвращается в очередь, сообщение не подтверждается
+		s.releaseImage(ctx, id, lease)
+		return fmt.Errorf("[worker] job %s: interrupted on image %d: %w", job.ID, id, ctx.Err())
+	}
+	if err != nil {
+		s.log.ErrorContext(ctx, "failed to process image",
+			"attempt", job.Attempt, "max_attempts", s.retry.MaxAttempts, logger.Err(err))
+		return s.retryJob(ctx, job, err)
+	}
+	metrics.JobFinished(metrics.JobSucceeded)
+	s.log.InfoContext(ctx, "image processed", "attempt", job.Attempt)
+	return nil
 }
 
-// AddImage добавляет новую запись
-func (s *Service) AddImage(ctx context.Context, img *model.Image) (int, error) {
-	return s.db.AddImage(ctx, img)
+// retryJob переотправляет задание с задержкой, которую выдерживает очередь, а после исчерпания попыток
+// перекладывает его в dead-letter топик и помечает изображение как failed
+func (s *Service) retryJob(ctx context.Context, job *model.Job, cause error) error {
+	job.LastError = cause.Error()
+	key := strconv.Itoa(job.ImageID)
+
+	if job.Attempt < s.retry.MaxAttempts {
+		delay := s.retry.backoff(job.Attempt)
+		job.Attempt++
+		job.NotBefore = time.Now().UTC().Add(delay)
+		msg, err := encodeJob(job)
+		if err != nil {
+			return err
+		}
+		err = s.setStatus(ctx, job.ImageID, model.StatusEnqueued, job.LastError)
+		if err != nil {
+			s.log.ErrorContext(ctx, "failed to mark image as enqueued", logger.Err(err))
+		}
+		err = s.queue.ProduceRetry(ctx, key, msg, job.NotBefore)
+		if err != nil {
+			return fmt.Errorf("[worker] failed to schedule retry of job %s: %w", job.ID, err)
+		}
+		metrics.JobFinished(metrics.JobRetried)
+		s.log.InfoContext(ctx, "job scheduled for retry", "attempt", job.Attempt, "delay", delay)
+		return nil
+	}
+
+	msg, err := encodeJob(job)
+	if err != nil {
+		return err
+	}
+	err = s.queue.ProduceDeadLetter(ctx, key, msg)
+	if err != nil {
+		return fmt.Errorf("[worker] failed to dead-letter job %s: %w", job.ID, err)
+	}
+	metrics.JobFinished(metrics.JobFailed)
+	s.log.ErrorContext(ctx, "job moved to dead letters", "attempts", job.Attempt)
+
+	err = s.setStatus(ctx, job.ImageID, model.StatusFailed, job.LastError)
+	if err != nil {
+		s.log.ErrorContext(ctx, "failed to mark image as failed", logger.Err(err))
+	}
+	return nil
 }
 
-// UpdateImage обновляет запись
-func (s *Service) UpdateImage(ctx context.Context, img *model.Image) error {
-	return s.db.UpdateImage(ctx, img)
+// GetImage возвращает изображение по ID вместе с его вариантами; чужие изображения
+// для пользователя без роли администратора не существуют
+func (s *Service) GetImage(ctx context.Context, id int) (*model.Image, error) {
+	scope, err := ownerScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	img, err := s.db.GetImage(ctx, id, scope)
+	if err != nil {
+		return nil, err
+	}
+	img.Variants, err = s.db.GetImageVariants(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// AddImage добавляет новую запись; для уже существующего изображения возвращает его id и false
+func (s *Service) AddImage(ctx context.Context, img *model.Image) (int, bool, error) {
+	return s.db.AddImage(ctx, img, nil)
 }
 
-// GetAllImages возвращает все изображения
-func (s *Service) GetAllImages(ctx context.Context) ([]*model.Image, error) {
-	return s.db.GetAllImages(ctx)
+// ListImages возвращает страницу списка изображений; limit ограничивается диапазоном 1..maxListLimit.
+// Пользователь видит только свои изображения, администратор может отфильтровать по владельцу
+func (s *Service) ListImages(ctx context.Context, q *model.ImageQuery) (*model.ImagePage, error) {
+	scope, err := ownerScope(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !scope.All {
+		q.OwnerID = scope.OwnerID
+	}
+	switch {
+	case q.Limit <= 0:
+		q.Limit = defaultListLimit
+	case q.Limit > maxListLimit:
+		q.Limit = maxListLimit
+	}
+	return s.db.ListImages(ctx, q)
 }
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
+)
+
+// jobReleaseTimeout - сколько ждать прерванные задания, возвращающие изображения в очередь
+const jobReleaseTimeout = 5 * time.Second
+
+// StopAccepting переводит сервис в режим завершения: новые загрузки отклоняются
+func (s *Service) StopAccepting() {
+	s.draining.Store(true)
+}
+
+// Draining сообщает, что сервис завершает работу и не принимает загрузки
+func (s *Service) Draining() bool {
+	return s.draining.Load()
+}
+
+// WaitJobs ждет, пока потребитель очереди, остановленный отменой контекста StartConsumer,
+// завершит начатые задания и зафиксирует смещения. Если ctx истекает раньше, задания прерываются,
+// а их изображения возвращаются в очередь
+func (s *Service) WaitJobs(ctx context.Context) {
+	if s.consumerDone == nil {
+		return
+	}
+	select {
+	case <-s.consumerDone:
+		return
+	case <-ctx.Done():
+	}
+
+	s.log.Warn("shutdown timeout, interrupting jobs in progress")
+	s.cancelJobs()
+	select {
+	case <-s.consumerDone:
+	case <-time.After(jobReleaseTimeout):
+		s.log.Error("jobs did not stop in time")
+	}
+}
+
+// jobContext возвращает контекст для начатого задания: остановка чтения очереди его не отменяет,
+// задание прерывается только по истечении таймаута завершения сервиса (см. WaitJobs)
+func (s *Service) jobContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(s.jobs, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// releaseImage снимает аренду прерванного задания и возвращает изображение в очередь,
+// не засчитывая попытку
+func (s *Service) releaseImage(ctx context.Context, id int, lease *model.Lease) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jobReleaseTimeout)
+	defer cancel()
+	err := s.db.ReleaseImage(ctx, id, lease)
+	if err != nil {
+		s.log.ErrorContext(ctx, "failed to release image", logger.Err(err))
+	}
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+)
+
+// transitions - допустимые переходы статусов изображения.
+// processing -> processing допускается для повторной доставки задания после сбоя воркера,
+// когда истекла аренда упавшего воркера (см. ClaimImage)
+var transitions = map[model.Status][]model.Status{
+	model.StatusUploaded:   {model.StatusEnqueued, model.StatusFailed, model.StatusDeleting},
+	model.StatusEnqueued:   {model.StatusProcessing, model.StatusFailed, model.StatusDeleting},
+	model.StatusProcessing: {model.StatusProcessing, model.StatusProcessed, model.StatusEnqueued, model.StatusFailed, model.StatusDeleting},
+	model.StatusProcessed:  {model.StatusEnqueued, model.StatusDeleting},
+	model.StatusFailed:     {model.StatusEnqueued, model.StatusDeleting},
+	model.StatusDeleting:   {model.StatusDeleting},
+}
+
+// canTransition сообщает, разрешен ли переход from -> to
+func canTransition(from, to model.Status) bool {
+	for _, st := range transitions[from] {
+		if st == to {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedFrom возвращает статусы, из которых разрешен переход в to
+func allowedFrom(to model.Status) []model.Status {
+	var from []model.Status
+	for st := range transitions {
+		if canTransition(st, to) {
+			from = append(from, st)
+		}
+	}
+	return from
+}
+
+// setStatus атомарно переводит изображение в статус to; при недопустимом текущем статусе
+// возвращается model.ErrStatusConflict
+func (s *Service) setStatus(ctx context.Context, id int, to model.Status, errMsg string) error {
+	return s.db.UpdateImageStatus(ctx, id, allowedFrom(to), to, errMsg)
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/Vladimirmoscow84/Image_processor/internal/pipeline"
+	memoryqueue "github.com/Vladimirmoscow84/Image_processor/internal/queue_broker/memory_queue"
+	filestorage "github.com/Vladimirmoscow84/Image_processor/internal/storage/file_storage"
+	"github.com/Vladimirmoscow84/Image_processor/internal/tracing"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TestTracePropagation проверяет, что обработка задания продолжает трассу HTTP-запроса,
+// поставившего его в очередь: через outbox, очередь в памяти и воркер
+func TestTracePropagation(t *testing.T) {
+	_, err := tracing.Setup(context.Background(), tracing.Config{Exporter: tracing.ExporterNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+		provider.Shutdown(context.Background())
+	})
+
+	files, err := filestorage.New(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files.Close()
+	putObject(t, files, "originals/traced.jpg", testJPEG(t))
+
+	repo := newFakeRepo(&model.Image{ID: 1, OriginalPath: "originals/traced.jpg", Status: model.StatusUploaded})
+	queue := memoryqueue.New(1, slog.New(slog.DiscardHandler))
+	svc, err := New(repo, files, queue, pipeline.Default(), DefaultRetryPolicy(), nopCache{},
+		pipeline.TransformConfig{}, UploadLimits{}, time.Minute, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// серверный спан загрузки, как его открывает tracingMiddleware для маршрута POST /upload
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reqCtx, httpSpan := provider.Tracer("test").Start(ctx, "POST /upload",
+		trace.WithSpanKind(trace.SpanKindServer))
+	err = svc.EnqueueImage(reqCtx, 1, nil)
+	httpSpan.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relayDone := svc.StartOutboxRelay(ctx, 10*time.Millisecond, 10)
+	svc.StartConsumer(ctx)
+	waitFor(t, 10*time.Second, func() bool { return repo.image(1).Status == model.StatusProcessed })
+	cancel()
+	svc.WaitJobs(context.Background())
+	<-relayDone
+
+	spans := make(map[trace.SpanID]sdktrace.ReadOnlySpan)
+	var worker sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		spans[s.SpanContext().SpanID()] = s
+		if s.Name() == "service.handleJob" {
+			worker = s
+		}
+	}
+	if worker == nil {
+		t.Fatal("no worker span recorded")
+	}
+
+	httpTrace := httpSpan.SpanContext().TraceID()
+	if got := worker.Parent().TraceID(); got != httpTrace {
+		t.Fatalf("worker span parent trace = %s, want HTTP trace %s", got, httpTrace)
+	}
+
+	// цепочка родителей спана воркера доходит до HTTP-спана
+	var chain []string
+	for parent := worker.Parent(); parent.IsValid(); {
+		if parent.SpanID() == httpSpan.SpanContext().SpanID() {
+			return
+		}
+		s, ok := spans[parent.SpanID()]
+		if !ok {
+			break
+		}
+		chain = append(chain, s.Name())
+		parent = s.Parent()
+	}
+	t.Fatalf("worker span is not a descendant of the HTTP span, parents: %v", chain)
+}
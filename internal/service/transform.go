@@ -0,0 +1,118 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/pipeline"
+	"github.com/Vladimirmoscow84/Image_processor/internal/storage/blob"
+	"github.com/Vladimirmoscow84/Image_processor/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// derivedRenderAttempts - сколько раз строить производный файл, вытесняемый из кэша до открытия
+const derivedRenderAttempts = 3
+
+// TransformImage отдает изображение, построенное из оригинала по параметрам запроса;
+// результат кэшируется на диске, одинаковые одновременные запросы строятся один раз.
+// Без явного формата результат отдается в формате из accept, если так он получается меньше
+func (s *Service) TransformImage(ctx context.Context, id int, t pipeline.Transform, accept []string) (_ io.ReadSeekCloser, _ *blob.ObjectInfo, err error) {
+	ctx, span := tracer.Start(ctx, "service.TransformImage", trace.WithAttributes(attribute.Int("image.id", id)))
+	defer func() { tracing.End(span, err) }()
+
+	t, err = t.Normalize(s.transform)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scope, err := ownerScope(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	img, err := s.db.GetImage(ctx, id, scope)
+	if err != nil {
+		return nil, nil, err
+	}
+	if img.OriginalPath == "" {
+		return nil, nil, fmt.Errorf("[imageprocessor] original of image %d: %w", id, blob.ErrNotFound)
+	}
+
+	// одинаковые оригиналы разделяют кэш, записи без хеша различаются по пути оригинала
+	source := img.ContentHash
+	if source == "" {
+		source = img.OriginalPath
+	}
+	sum := sha256.Sum256([]byte(source + "|" + t.Key()))
+	etag := hex.EncodeToString(sum[:])
+	ext := t.Ext(img.OriginalPath)
+	key := etag + ext
+	span.SetAttributes(attribute.String("transform", t.Key()))
+
+	f, fi, err := s.openDerived(key, func() error {
+		return s.renderTransform(ctx, img.OriginalPath, t, key, ext)
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("[imageprocessor] failed to open transformed image: %w", err)
+	}
+
+	info := &blob.ObjectInfo{
+		Key:         key,
+		Size:        fi.Size(),
+		ContentType: pipeline.ContentType(ext),
+		ETag:        etag,
+		ModTime:     fi.ModTime(),
+	}
+	if t.Format != "" {
+		return f, info, nil
+	}
+	return s.negotiate(ctx, f, info, accept)
+}
+
+// openDerived открывает производный файл из кэша, при промахе строит его через render.
+// Файл, вытесненный между поиском в индексе и открытием или сразу после построения, считается
+// промахом и строится заново, но не больше derivedRenderAttempts раз
+func (s *Service) openDerived(key string, render func() error) (*os.File, os.FileInfo, error) {
+	for attempt := 0; ; attempt++ {
+		f, fi, err := s.derived.Get(key)
+		if !errors.Is(err, fs.ErrNotExist) || attempt == derivedRenderAttempts {
+			return f, fi, err
+		}
+		_, err, _ = s.renders.Do(key, func() (any, error) {
+			return nil, render()
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+}
+
+// renderTransform строит изображение из оригинала и кладет его в кэш
+func (s *Service) renderTransform(ctx context.Context, origPath string, t pipeline.Transform, key, ext string) (err error) {
+	ctx, span := tracer.Start(ctx, "service.renderTransform")
+	defer func() { tracing.End(span, err) }()
+
+	src, exif, err := s.openImage(ctx, origPath)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	err = pipeline.Encode(&buf, s.pipeline.Transform(src, t), ext, t.Quality)
+	if err != nil {
+		return err
+	}
+
+	err = s.derived.Put(key, pipeline.EmbedEXIF(buf.Bytes(), ext, exif))
+	if err != nil {
+		return fmt.Errorf("[imageprocessor] failed to cache transformed image: %w", err)
+	}
+	return nil
+}
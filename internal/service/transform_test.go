@@ -0,0 +1,95 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// evictingCache - кэш на диске, который вытесняет файл перед открытием, пока не исчерпан evict
+type evictingCache struct {
+	dir   string
+	evict int
+}
+
+func (c *evictingCache) Get(key string) (*os.File, os.FileInfo, error) {
+	path := filepath.Join(c.dir, key)
+	if c.evict > 0 {
+		c.evict--
+		os.Remove(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open %s: %w", key, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, fi, nil
+}
+
+func (c *evictingCache) Put(key string, data []byte) error {
+	return os.WriteFile(filepath.Join(c.dir, key), data, 0644)
+}
+
+// TestOpenDerivedEvicted проверяет, что файл, вытесненный из кэша до открытия, строится заново,
+// а не превращается в ошибку
+func TestOpenDerivedEvicted(t *testing.T) {
+	cases := []struct {
+		name       string
+		evict      int
+		wantRender int
+		wantErr    bool
+	}{
+		{"cache hit", 0, 0, false},
+		{"evicted before first open", 1, 1, false},
+		{"evicted again after render", 2, 2, false},
+		{"evicted on every open", 100, derivedRenderAttempts, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cache := &evictingCache{dir: t.TempDir(), evict: tc.evict}
+			if err := cache.Put("k.jpg", []byte("cached")); err != nil {
+				t.Fatal(err)
+			}
+			svc := &Service{derived: cache}
+
+			renders := 0
+			f, _, err := svc.openDerived("k.jpg", func() error {
+				renders++
+				return cache.Put("k.jpg", []byte("rendered"))
+			})
+			if renders != tc.wantRender {
+				t.Fatalf("rendered %d times, want %d", renders, tc.wantRender)
+			}
+			if tc.wantErr {
+				if !errors.Is(err, fs.ErrNotExist) {
+					t.Fatalf("openDerived = %v, want fs.ErrNotExist", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			data, err := io.ReadAll(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "rendered"
+			if tc.wantRender == 0 {
+				want = "cached"
+			}
+			if string(data) != want {
+				t.Fatalf("data = %q, want %q", data, want)
+			}
+		})
+	}
+}
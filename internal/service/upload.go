@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/auth"
+	"github.com/Vladimirmoscow84/Image_processor/internal/metrics"
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/Vladimirmoscow84/Image_processor/internal/pipeline"
+	"github.com/Vladimirmoscow84/Image_processor/internal/tracing"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// originalExts - допустимые типы оригиналов, определенные по содержимому, и их расширения
+var originalExts = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// UploadLimits - ограничения на загружаемые файлы; нулевое значение снимает ограничение
+type UploadLimits struct {
+	MaxBytes  int64
+	MaxWidth  int
+	MaxHeight int
+	MaxPixels int64
+}
+
+// DefaultUploadLimits возвращает ограничения по умолчанию
+func DefaultUploadLimits() UploadLimits {
+	return UploadLimits{
+		MaxBytes:  20 << 20,
+		MaxWidth:  10000,
+		MaxHeight: 10000,
+		MaxPixels: 50_000_000,
+	}
+}
+
+// MaxUploadBytes возвращает максимальный размер загружаемого файла
+func (s *Service) MaxUploadBytes() int64 {
+	return s.limits.MaxBytes
+}
+
+// contentKey возвращает ключ хранения оригинала по хешу содержимого,
+// разложенный по подкаталогам: originals/ab/cd/abcd...<ext>
+func contentKey(hash, ext string) string {
+	return path.Join("originals", hash[:2], hash[2:4], hash+ext)
+}
+
+// UploadImage сохраняет оригинал по хешу содержимого и добавляет запись об изображении.
+// Одинаковое содержимое хранится в одном объекте, на который ведется подсчет ссылок;
+// для уже загруженного файла с теми же параметрами img заполняется существующей записью.
+// Новое изображение ставится в очередь обработки в той же транзакции
+func (s *Service) UploadImage(ctx context.Context, img *model.Image, r io.Reader) (id int, err error) {
+	ctx, span := tracer.Start(ctx, "service.UploadImage")
+	defer func() {
+		span.SetAttributes(attribute.Int("image.id", id))
+		tracing.End(span, err)
+	}()
+
+	if s.Draining() {
+		return 0, model.ErrShuttingDown
+	}
+	principal, ok := auth.FromContext(ctx)
+	if !ok {
+		return 0, model.ErrUnauthenticated
+	}
+	img.OwnerID = principal.Subject
+
+	// хеш известен только после чтения всего потока, поэтому загрузка буферизуется во временный файл
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return 0, fmt.Errorf("[imageprocessor] failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if s.limits.MaxBytes > 0 {
+		r = io.LimitReader(r, s.limits.MaxBytes+1)
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		return 0, fmt.Errorf("[imageprocessor] failed to read upload: %w", err)
+	}
+	if s.limits.MaxBytes > 0 && size > s.limits.MaxBytes {
+		return 0, fmt.Errorf("[imageprocessor] %w: limit is %d bytes", model.ErrFileTooLarge, s.limits.MaxBytes)
+	}
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, fmt.Errorf("[imageprocessor] failed to rewind upload: %w", err)
+	}
+
+	mimeType, err := s.checkUpload(tmp)
+	if err != nil {
+		return 0, err
+	}
+	metrics.ObserveUpload(size)
+	ext := originalExts[mimeType]
+	img.Format = strings.TrimPrefix(mimeType, "image/")
+
+	meta, err := pipeline.ReadMetadata(tmp)
+	if err != nil {
+		s.log.WarnContext(ctx, "failed to read metadata", "file", img.OriginalName, logger.Err(err))
+	}
+	if meta != nil {
+		img.CameraMake = meta.CameraMake
+		img.CameraModel = meta.CameraModel
+		img.TakenAt = meta.TakenAt
+		img.Width = meta.Width
+		img.Height = meta.Height
+		img.Orientation = meta.Orientation
+	}
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return 0, fmt.Errorf("[imageprocessor] failed to rewind upload: %w", err)
+	}
+
+	hash := hex.EncodeToString(h.Sum(nil))
+	key := contentKey(hash, ext)
+
+	// повторная загрузка того же файла с теми же параметрами возвращает существующее изображение
+	existing, err := s.db.GetImageByOriginalPath(ctx, key, img.OwnerID, img.Options)
+	if err == nil {
+		*img = *existing
+		return existing.ID, nil
+	}
+	if !errors.Is(err, model.ErrImageNotFound) {
+		return 0, fmt.Errorf("[imageprocessor] failed to look up existing image: %w", err)
+	}
+
+	// ссылка регистрируется до записи объекта: удаление последней ссылки
+	// выполняется под блокировкой записи blob и не может удалить новый объект
+	err = s.db.AcquireBlob(ctx, hash, key, size)
+	if err != nil {
+		return 0, fmt.Errorf("[imageprocessor] failed to register blob: %w", err)
+	}
+
+	err = s.store.Put(ctx, key, tmp, size, pipeline.ContentType(ext))
+	if err != nil {
+		s.releaseOriginal(ctx, hash)
+		return 0, fmt.Errorf("[imageprocessor] failed to store original: %w", err)
+	}
+
+	// запись создается сразу в статусе enqueued вместе с заданием в outbox
+	img.OriginalPath = key
+	img.ContentHash = hash
+	img.Status = model.StatusEnqueued
+	id, created, err := s.db.AddImage(ctx, img, func(id int) (*model.OutboxMessage, error) {
+		return s.jobMessage(ctx, id, img.Options)
+	})
+	if err != nil {
+		s.releaseOriginal(ctx, hash)
+		return 0, fmt.Errorf("[imageprocessor] failed to add image record: %w", err)
+	}
+	if !created {
+		// параллельная загрузка того же файла успела создать запись - ее ссылка на blob уже учтена
+		s.releaseOriginal(ctx, hash)
+		existing, err = s.db.GetImage(ctx, id, model.OwnerScope{OwnerID: img.OwnerID})
+		if err != nil {
+			return 0, fmt.Errorf("[imageprocessor] failed to load existing image: %w", err)
+		}
+		*img = *existing
+		return id, nil
+	}
+	metrics.JobEnqueued()
+	s.log.InfoContext(logger.WithImageID(ctx, id), "image uploaded", "file", img.OriginalName, "bytes", size)
+	return id, nil
+}
+
+// checkUpload определяет тип файла по сигнатуре и проверяет размеры изображения по заголовку,
+// не декодируя пиксели; возвращает MIME-тип оригинала
+func (s *Service) checkUpload(f io.ReadSeeker) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("[imageprocessor] failed to read upload: %w", err)
+	}
+	mimeType := http.DetectContentType(head[:n])
+	if _, ok := originalExts[mimeType]; !ok {
+		return "", fmt.Errorf("[imageprocessor] %w: %s", model.ErrUnsupportedMediaType, mimeType)
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", fmt.Errorf("[imageprocessor] failed to rewind upload: %w", err)
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return "", fmt.Errorf("[imageprocessor] %w: %v", model.ErrInvalidImage, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return "", fmt.Errorf("[imageprocessor] %w: empty image", model.ErrInvalidImage)
+	}
+	if (s.limits.MaxWidth > 0 && cfg.Width > s.limits.MaxWidth) || (s.limits.MaxHeight > 0 && cfg.Height > s.limits.MaxHeight) {
+		return "", fmt.Errorf("[imageprocessor] %w: %dx%d exceeds %dx%d",
+			model.ErrInvalidImage, cfg.Width, cfg.Height, s.limits.MaxWidth, s.limits.MaxHeight)
+	}
+	if s.limits.MaxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > s.limits.MaxPixels {
+		return "", fmt.Errorf("[imageprocessor] %w: %d pixels exceeds %d",
+			model.ErrInvalidImage, int64(cfg.Width)*int64(cfg.Height), s.limits.MaxPixels)
+	}
+
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", fmt.Errorf("[imageprocessor] failed to rewind upload: %w", err)
+	}
+	return mimeType, nil
+}
+
+// releaseOriginal снимает ссылку на оригинал и удаляет объект вместе с последней ссылкой
+func (s *Service) releaseOriginal(ctx context.Context, hash string) error {
+	err := s.db.ReleaseBlob(ctx, hash, func(key string) error {
+		return s.store.Delete(ctx, key)
+	})
+	if err != nil {
+		s.log.ErrorContext(ctx, "failed to release blob", "hash", hash, logger.Err(err))
+		return err
+	}
+	return nil
+}
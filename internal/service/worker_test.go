@@ -0,0 +1,213 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/Vladimirmoscow84/Image_processor/internal/pipeline"
+	memoryqueue "github.com/Vladimirmoscow84/Image_processor/internal/queue_broker/memory_queue"
+	"github.com/Vladimirmoscow84/Image_processor/internal/storage/blob"
+	filestorage "github.com/Vladimirmoscow84/Image_processor/internal/storage/file_storage"
+)
+
+// flakyStore отказывает в первой записи объекта, ключ которого содержит fail
+type flakyStore struct {
+	blob.Store
+	fail string
+
+	mu     sync.Mutex
+	failed bool
+}
+
+func (s *flakyStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	s.mu.Lock()
+	fail := !s.failed && strings.Contains(key, s.fail)
+	if fail {
+		s.failed = true
+	}
+	s.mu.Unlock()
+	if fail {
+		return errors.New("storage unavailable")
+	}
+	return s.Store.Put(ctx, key, r, size, contentType)
+}
+
+func putObject(t *testing.T, store blob.Store, key string, data []byte) {
+	t.Helper()
+	err := store.Put(context.Background(), key, bytes.NewReader(data), int64(len(data)), "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testJPEG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for x := range 64 {
+		for y := range 48 {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 5), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestWorkerEndToEnd проводит задания через outbox и очередь в памяти до воркера:
+// успешная обработка, успех после повтора и перенос в dead letters после исчерпания попыток
+func TestWorkerEndToEnd(t *testing.T) {
+	files, err := filestorage.New(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files.Close()
+	putObject(t, files, "originals/ok.jpg", testJPEG(t))
+	putObject(t, files, "originals/flaky.jpg", testJPEG(t))
+	putObject(t, files, "originals/broken.jpg", []byte("not an image"))
+
+	const (
+		okID     = 1
+		brokenID = 2
+		flakyID  = 3
+	)
+	repo := newFakeRepo(
+		&model.Image{ID: okID, OriginalPath: "originals/ok.jpg", Status: model.StatusUploaded},
+		&model.Image{ID: brokenID, OriginalPath: "originals/broken.jpg", Status: model.StatusUploaded},
+		&model.Image{ID: flakyID, OriginalPath: "originals/flaky.jpg", Status: model.StatusUploaded},
+	)
+	store := &flakyStore{Store: files, fail: "flaky-3."}
+	queue := memoryqueue.New(2, slog.New(slog.DiscardHandler))
+	retry := RetryPolicy{MaxAttempts: 3, BaseDelay: 20 * time.Millisecond, MaxDelay: 50 * time.Millisecond}
+
+	svc, err := New(repo, store, queue, pipeline.Default(), retry, nopCache{},
+		pipeline.TransformConfig{}, UploadLimits{}, time.Minute, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, id := range []int{okID, brokenID, flakyID} {
+		if err := svc.EnqueueImage(ctx, id, nil); err != nil {
+			t.Fatalf("EnqueueImage(%d): %v", id, err)
+		}
+		if st := repo.image(id).Status; st != model.StatusEnqueued {
+			t.Fatalf("image %d status after enqueue = %s", id, st)
+		}
+	}
+	relayDone := svc.StartOutboxRelay(ctx, 10*time.Millisecond, 10)
+	svc.StartConsumer(ctx)
+
+	waitFor(t, 10*time.Second, func() bool {
+		return repo.image(okID).Status == model.StatusProcessed &&
+			repo.image(flakyID).Status == model.StatusProcessed &&
+			repo.image(brokenID).Status == model.StatusFailed
+	})
+	cancel()
+	svc.WaitJobs(context.Background())
+	<-relayDone
+
+	for _, id := range []int{okID, flakyID} {
+		img := repo.image(id)
+		if img.ProcessedPath == "" || img.ThumbnailPath == "" || len(img.Variants) == 0 {
+			t.Fatalf("image %d has no variants: %+v", id, img)
+		}
+		for _, v := range img.Variants {
+			if _, err := files.Stat(context.Background(), v.Path); err != nil {
+				t.Fatalf("variant %s of image %d not stored: %v", v.Name, id, err)
+			}
+		}
+	}
+	if n := repo.claimCount(okID); n != 1 {
+		t.Fatalf("image %d claimed %d times, want 1", okID, n)
+	}
+	if n := repo.claimCount(flakyID); n != 2 {
+		t.Fatalf("image %d claimed %d times, want 2 (failure and retry)", flakyID, n)
+	}
+	if n := repo.claimCount(brokenID); n != retry.MaxAttempts {
+		t.Fatalf("image %d claimed %d times, want %d", brokenID, n, retry.MaxAttempts)
+	}
+
+	dead := queue.DeadLetters()
+	if len(dead) != 1 {
+		t.Fatalf("dead letters = %d, want 1", len(dead))
+	}
+	job, err := decodeJob(dead[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ImageID != brokenID || job.Attempt != retry.MaxAttempts || job.LastError == "" {
+		t.Fatalf("dead letter job = %+v", job)
+	}
+	if msg := repo.image(brokenID).ErrorMessage; msg == "" {
+		t.Fatal("failed image has no error message")
+	}
+}
+
+// TestHandleJobGetImageError проверяет, что сбой чтения записи не подтверждает задание,
+// а отсутствие изображения - подтверждает
+func TestHandleJobGetImageError(t *testing.T) {
+	cases := []struct {
+		name    string
+		getErr  error
+		wantErr bool
+	}{
+		{"image deleted", model.ErrImageNotFound, false},
+		{"database unavailable", errors.New("connection refused"), true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repo := newFakeRepo(&model.Image{ID: 1, OriginalPath: "originals/a.jpg", Status: model.StatusEnqueued})
+			repo.getErr = tc.getErr
+			queue := memoryqueue.New(1, slog.New(slog.DiscardHandler))
+			svc, err := New(repo, &flakyStore{}, queue, pipeline.Default(), DefaultRetryPolicy(), nopCache{},
+				pipeline.TransformConfig{}, UploadLimits{}, time.Minute, slog.New(slog.DiscardHandler))
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg, err := encodeJob(newJob(1, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = svc.handleJob(context.Background(), msg)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("handleJob = %v, want error: %v", err, tc.wantErr)
+			}
+			if tc.wantErr && !errors.Is(err, tc.getErr) {
+				t.Fatalf("handleJob = %v, want wrapped %v", err, tc.getErr)
+			}
+			if st := repo.image(1).Status; st != model.StatusEnqueued {
+				t.Fatalf("image status = %s, want enqueued", st)
+			}
+			if n := repo.claimCount(1); n != 0 {
+				t.Fatalf("image claimed %d times, want 0", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrNotFound - объект с указанным ключом отсутствует в хранилище
+var ErrNotFound = errors.New("blob not found")
+
+// ErrInvalidKey - ключ не является относительным путем внутри хранилища
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// ObjectInfo - метаданные объекта хранилища
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ETag        string
+	ModTime     time.Time
+}
+
+// Store - хранилище бинарных объектов по ключу. Ключи - относительные пути с разделителем "/"
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, *ObjectInfo, error)
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// WritableChecker - хранилище, умеющее проверить, что в него можно писать; используется в /readyz
+type WritableChecker interface {
+	CheckWritable(ctx context.Context) error
+}
+
+// CleanKey проверяет ключ и приводит его к каноническому виду. Допускаются только относительные
+// пути с разделителем "/" без элементов ".." - такой ключ не может указывать за пределы хранилища
+func CleanKey(key string) (string, error) {
+	if key == "" || strings.ContainsAny(key, "\\\x00") || path.IsAbs(key) || filepath.IsAbs(key) || filepath.VolumeName(key) != "" {
+		return "", fmt.Errorf("[blob] %w: %q", ErrInvalidKey, key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("[blob] %w: %q", ErrInvalidKey, key)
+		}
+	}
+	clean := path.Clean(key)
+	if clean == "." {
+		return "", fmt.Errorf("[blob] %w: %q", ErrInvalidKey, key)
+	}
+	return clean, nil
+}
+
+var tracer = otel.Tracer("github.com/Vladimirmoscow84/Image_processor/internal/storage/blob")
+
+// StartSpan открывает спан операции op хранилища backend над объектом key
+func StartSpan(ctx context.Context, backend, op, key string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, backend+"."+op, trace.WithAttributes(
+		attribute.String("storage.backend", backend),
+		attribute.String("storage.key", key),
+	))
+}
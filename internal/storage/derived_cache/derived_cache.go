@@ -0,0 +1,238 @@
+package derivedcache
+
+import (
+	"container/list"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
+)
+
+const tmpDir = "tmp"
+
+// Cache - дисковый кэш производных изображений с вытеснением давно не запрашиваемых файлов
+// при превышении бюджета
+type Cache struct {
+	root   string
+	budget int64
+	log    *slog.Logger
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type entry struct {
+	key  string
+	size int64
+}
+
+// New - конструктор кэша; файлы, оставшиеся с прошлого запуска, учитываются в порядке времени записи
+func New(root string, budget int64, log *slog.Logger) (*Cache, error) {
+	if budget <= 0 {
+		return nil, fmt.Errorf("[derivedcache] budget must be positive")
+	}
+	err := os.MkdirAll(filepath.Join(root, tmpDir), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("[derivedcache] failed to create cache dir: %w", err)
+	}
+
+	c := &Cache{
+		root:    root,
+		budget:  budget,
+		log:     logger.OrDefault(log).With("component", "derivedcache"),
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	err = c.load()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.evict("")
+	c.mu.Unlock()
+	c.log.Info("cache loaded", "files", c.lru.Len(), "bytes", c.size)
+	return c, nil
+}
+
+// Get открывает файл из кэша; при отсутствии, в том числе если файл вытеснен после поиска
+// в индексе, возвращает ошибку fs.ErrNotExist
+func (c *Cache) Get(key string) (*os.File, os.FileInfo, error) {
+	err := validKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.mu.Lock()
+	el, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(el)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("[derivedcache] %s: %w", key, fs.ErrNotExist)
+	}
+
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.forget(key)
+		}
+		return nil, nil, fmt.Errorf("[derivedcache] failed to open %s: %w", key, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("[derivedcache] failed to stat %s: %w", key, err)
+	}
+	return f, fi, nil
+}
+
+// Put атомарно записывает файл в кэш и вытесняет старые файлы сверх бюджета
+func (c *Cache) Put(key string, data []byte) error {
+	err := validKey(key)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(c.root, tmpDir), "derived-*")
+	if err != nil {
+		return fmt.Errorf("[derivedcache] failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("[derivedcache] failed to write %s: %w", key, err)
+	}
+
+	dst := c.path(key)
+	err = os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return fmt.Errorf("[derivedcache] failed to create dir for %s: %w", key, err)
+	}
+	err = os.Rename(tmp.Name(), dst)
+	if err != nil {
+		return fmt.Errorf("[derivedcache] failed to move %s: %w", key, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.add(key, int64(len(data)))
+	c.evict(key)
+	return nil
+}
+
+// load восстанавливает индекс по файлам на диске
+func (c *Cache) load() error {
+	type file struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+
+	err := filepath.WalkDir(c.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p == filepath.Join(c.root, tmpDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if validKey(d.Name()) != nil || p != c.path(d.Name()) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, file{key: d.Name(), size: fi.Size(), modTime: fi.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("[derivedcache] failed to scan cache dir: %w", err)
+	}
+
+	slices.SortFunc(files, func(a, b file) int { return a.modTime.Compare(b.modTime) })
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range files {
+		c.add(f.key, f.size)
+	}
+	return nil
+}
+
+// add помещает запись в начало списка; вызывается под c.mu
+func (c *Cache) add(key string, size int64) {
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*entry)
+		c.size += size - e.size
+		e.size = size
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&entry{key: key, size: size})
+	c.size += size
+}
+
+// evict удаляет самые старые записи, пока размер кэша больше бюджета; запись keep не удаляется.
+// Вызывается под c.mu
+func (c *Cache) evict(keep string) {
+	for c.size > c.budget {
+		el := c.lru.Back()
+		if el == nil {
+			return
+		}
+		e := el.Value.(*entry)
+		if e.key == keep {
+			return
+		}
+		err := os.Remove(c.path(e.key))
+		if err != nil && !os.IsNotExist(err) {
+			c.log.Warn("failed to evict", "key", e.key, logger.Err(err))
+		}
+		c.lru.Remove(el)
+		delete(c.entries, e.key)
+		c.size -= e.size
+	}
+}
+
+// forget убирает из индекса запись, файл которой пропал с диска
+func (c *Cache) forget(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.size -= el.Value.(*entry).size
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+// path раскладывает файлы по подкаталогам по первым двум символам ключа
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.root, key[:2], key)
+}
+
+// validKey допускает только плоские имена файлов без разделителей пути
+func validKey(key string) error {
+	if len(key) < 3 || strings.ContainsAny(key, `/\`) || strings.HasPrefix(key, ".") {
+		return fmt.Errorf("[derivedcache] invalid key %q", key)
+	}
+	return nil
+}
@@ -2,183 +2,262 @@ package filestorage
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"image"
-	"image/draw"
-	"image/gif"
-	"image/jpeg"
-	"image/png"
 	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 
-	"github.com/disintegration/imaging"
+	"github.com/Vladimirmoscow84/Image_processor/internal/storage/blob"
+	"github.com/Vladimirmoscow84/Image_processor/internal/tracing"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
 )
 
+const tmpDir = "tmp"
+
+// FileStorage хранит объекты в каталоге Path. Все операции выполняются через os.Root,
+// поэтому ни ключ с "..", ни символическая ссылка не выводят за пределы каталога
 type FileStorage struct {
-	Path      string
-	watermark image.Image
+	Path string
+	root *os.Root
+	log  *slog.Logger
 }
 
 // New - конструктор файлового хранилища
-func New(path, watermarkPath string) (*FileStorage, error) {
-
+func New(path string, log *slog.Logger) (*FileStorage, error) {
+	log = logger.OrDefault(log).With("component", "filestorage")
 	if path == "" {
 		return nil, fmt.Errorf("[fileStorage] base path is empty")
 	}
 
-	fs := &FileStorage{
-		Path: path,
-	}
-
-	if watermarkPath != "" {
-		if _, err := os.Stat(watermarkPath); err == nil {
-			wm, err := imaging.Open(watermarkPath)
-			if err != nil {
-				fmt.Printf("[fileStorage] failed to load watermark: %v\n", err)
-			} else {
-				fs.watermark = wm
-				fmt.Println("[fileStorage] watermark loaded")
-			}
-		} else {
-			fmt.Printf("[fileStorage] watermark file not found, proceeding without watermark\n")
-		}
-	} else {
-		fmt.Println("[fileStorage] no watermark provided")
-	}
-
-	dirs := []string{
-		path,
-		filepath.Join(path, "originals"),
-		filepath.Join(path, "processed"),
-		filepath.Join(path, "thumbs"),
+	err := os.MkdirAll(filepath.Join(path, tmpDir), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("[fileStorage] failed to create dir: %w", err)
 	}
-	for _, dir := range dirs {
 
-		err := os.MkdirAll(dir, 0755)
-		if err != nil {
-			return nil, fmt.Errorf("[fileStorage] failed to create dir: %w", err)
-		}
+	root, err := os.OpenRoot(path)
+	if err != nil {
+		return nil, fmt.Errorf("[fileStorage] failed to open root: %w", err)
 	}
 
-	return fs, nil
+	log.Info("storage opened", "root", path)
+	return &FileStorage{
+		Path: path,
+		root: root,
+		log:  log,
+	}, nil
 }
 
-// Save сохраняет файл в локальное хранилище без обработки и возвращает путь к нему
-func (f *FileStorage) Save(ctx context.Context, origPath string) (string, error) {
-
-	filename := filepath.Base(origPath)
-	destPath := filepath.Join(f.Path, "originals", filename)
+// Put записывает объект во временный файл и атомарно переименовывает его в key
+func (f *FileStorage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (err error) {
+	_, span := blob.StartSpan(ctx, "file", "Put", key)
+	defer func() { tracing.End(span, err) }()
 
-	err := os.MkdirAll(filepath.Dir(destPath), 0755)
+	key, err = blob.CleanKey(key)
 	if err != nil {
-		return "", fmt.Errorf("[fileStorage] failed to create subdir: %w", err)
+		return err
+	}
+	if key == tmpDir || strings.HasPrefix(key, tmpDir+"/") {
+		return fmt.Errorf("[fileStorage] %w: %q is reserved", blob.ErrInvalidKey, key)
 	}
 
-	input, err := os.Open(origPath)
+	err = f.root.MkdirAll(path.Dir(key), 0755)
 	if err != nil {
-		return "", fmt.Errorf("[fileStorage] failed to open source: %w", err)
+		return fmt.Errorf("[fileStorage] failed to create subdir: %w", err)
 	}
-	defer input.Close()
 
-	output, err := os.Create(destPath)
+	tmpName, err := tempName()
 	if err != nil {
-		return "", fmt.Errorf("[fileStorage] failed to create file: %w", err)
+		return err
 	}
-	defer output.Close()
+	tmp, err := f.root.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("[fileStorage] failed to create temp file: %w", err)
+	}
+	defer f.root.Remove(tmpName)
 
-	_, err = io.Copy(output, input)
+	_, err = io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("[fileStorage] failed to save file: %w", err)
+	}
+	err = tmp.Close()
 	if err != nil {
-		return "", fmt.Errorf("[fileStorage] failed to save file: %w", err)
+		return fmt.Errorf("[fileStorage] failed to save file: %w", err)
 	}
 
-	return destPath, nil
+	err = f.root.Rename(tmpName, key)
+	if err != nil {
+		return fmt.Errorf("[fileStorage] failed to move file: %w", err)
+	}
+	return nil
 }
 
-// SaveImage сохраняет image.Image в локальное хранилище с водяным знаком и нужным форматом
-func (f *FileStorage) SaveImage(ctx context.Context, img image.Image, destPath string) (string, error) {
-	fullPath := filepath.Join(f.Path, destPath)
-
-	err := os.MkdirAll(filepath.Dir(fullPath), 0755)
+// Get открывает объект на чтение
+func (f *FileStorage) Get(ctx context.Context, key string) (io.ReadSeekCloser, *blob.ObjectInfo, error) {
+	key, err := blob.CleanKey(key)
 	if err != nil {
-		return "", fmt.Errorf("[filestorage] failed to create directories: %w", err)
+		return nil, nil, err
 	}
 
-	// Добавление водянго знака если он задан
-	if f.watermark != nil {
-		img = applyWatermark(img, f.watermark)
+	file, err := f.root.Open(key)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, blob.ErrNotFound
+		}
+		return nil, nil, fmt.Errorf("[fileStorage] failed to open file: %w", err)
 	}
 
-	outFile, err := os.Create(fullPath)
+	st, err := file.Stat()
 	if err != nil {
-		return "", fmt.Errorf("[filestorage] failed to create file: %w", err)
+		file.Close()
+		return nil, nil, fmt.Errorf("[fileStorage] failed to stat file: %w", err)
 	}
-	defer outFile.Close()
-
-	ext := strings.ToLower(filepath.Ext(fullPath))
-	switch ext {
-	case ".jpg", ".jpeg":
-		err = jpeg.Encode(outFile, img, &jpeg.Options{Quality: 90})
-	case ".png":
-		err = png.Encode(outFile, img)
-	case ".gif":
-		err = gif.Encode(outFile, img, nil)
-	default:
-		// по умолчанию JPG
-		err = jpeg.Encode(outFile, img, &jpeg.Options{Quality: 90})
+	if !st.Mode().IsRegular() {
+		file.Close()
+		return nil, nil, blob.ErrNotFound
 	}
+	return file, objectInfo(key, st), nil
+}
+
+// Stat возвращает метаданные объекта
+func (f *FileStorage) Stat(ctx context.Context, key string) (*blob.ObjectInfo, error) {
+	key, err := blob.CleanKey(key)
 	if err != nil {
-		return "", fmt.Errorf("[filestorage] failed to encode image: %w", err)
+		return nil, err
 	}
 
-	return fullPath, nil
+	st, err := f.root.Stat(key)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, blob.ErrNotFound
+		}
+		return nil, fmt.Errorf("[fileStorage] failed to stat file: %w", err)
+	}
+	if !st.Mode().IsRegular() {
+		return nil, blob.ErrNotFound
+	}
+	return objectInfo(key, st), nil
 }
 
-// Delete удаляет файл из локального хранилища
-func (f *FileStorage) Delete(ctx context.Context, destPath string) error {
-	if destPath == "" {
+// Delete удаляет объект из локального хранилища
+func (f *FileStorage) Delete(ctx context.Context, key string) (err error) {
+	if key == "" {
 		return nil
 	}
-	fullPath := destPath
-	if !filepath.IsAbs(destPath) {
-		fullPath = filepath.Join(f.Path, destPath)
+	_, span := blob.StartSpan(ctx, "file", "Delete", key)
+	defer func() { tracing.End(span, err) }()
+
+	key, err = blob.CleanKey(key)
+	if err != nil {
+		return err
 	}
 
-	err := os.Remove(fullPath)
+	st, err := f.root.Lstat(key)
+	if err == nil && st.IsDir() {
+		return fmt.Errorf("[fileStorage] %w: %q is a directory", blob.ErrInvalidKey, key)
+	}
+	if err == nil {
+		err = f.root.Remove(key)
+	}
 
 	if err != nil && !os.IsNotExist(err) {
 		return fmt.Errorf("[fileStorage] failed to delete file: %w", err)
 	}
+	f.log.DebugContext(ctx, "object deleted", "key", key)
 	return nil
 }
 
-// ResizeImage изменяет размер изображения
-func ResizeImage(img image.Image, width, height int) image.Image {
-	return imaging.Resize(img, width, height, imaging.Lanczos)
-}
+// List возвращает все объекты с ключами, начинающимися с prefix
+func (f *FileStorage) List(ctx context.Context, prefix string) ([]blob.ObjectInfo, error) {
+	dir := "."
+	if prefix != "" {
+		var err error
+		dir, err = blob.CleanKey(prefix)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-// CreateThumbnail создает миниатюру
-func CreateThumbnail(img image.Image, width, height int) image.Image {
-	return imaging.Thumbnail(img, width, height, imaging.Lanczos)
+	var objects []blob.ObjectInfo
+	err := fs.WalkDir(f.root.FS(), dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if p == tmpDir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		st, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, *objectInfo(p, st))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[fileStorage] failed to list files: %w", err)
+	}
+	return objects, nil
 }
 
-// applyWatermark накладывает водяной знак на изображение
-func applyWatermark(base, watermark image.Image) image.Image {
-	result := imaging.Clone(base)
+// Close освобождает дескриптор корневого каталога
+func (f *FileStorage) Close() error {
+	f.log.Info("storage closed")
+	return f.root.Close()
+}
 
-	offset := image.Pt(
-		base.Bounds().Dx()-watermark.Bounds().Dx()-10,
-		base.Bounds().Dy()-watermark.Bounds().Dy()-10,
-	)
+// CheckWritable создает и удаляет временный файл в каталоге хранилища
+func (f *FileStorage) CheckWritable(ctx context.Context) error {
+	name, err := tempName()
+	if err != nil {
+		return err
+	}
+	file, err := f.root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("[fileStorage] storage is not writable: %w", err)
+	}
+	file.Close()
+	err = f.root.Remove(name)
+	if err != nil {
+		return fmt.Errorf("[fileStorage] failed to remove probe file: %w", err)
+	}
+	return nil
+}
 
-	draw.Draw(
-		result,
-		watermark.Bounds().Add(offset),
-		watermark,
-		image.Point{},
-		draw.Over,
-	)
+// tempName возвращает случайное имя временного файла внутри каталога tmp
+func tempName() (string, error) {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("[fileStorage] failed to generate temp name: %w", err)
+	}
+	return path.Join(tmpDir, "put-"+hex.EncodeToString(b)), nil
+}
 
-	return result
+// objectInfo строит метаданные по файлу; ETag - размер и время изменения
+func objectInfo(key string, st fs.FileInfo) *blob.ObjectInfo {
+	return &blob.ObjectInfo{
+		Key:         key,
+		Size:        st.Size(),
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+		ETag:        strconv.FormatInt(st.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(st.Size(), 36),
+		ModTime:     st.ModTime(),
+	}
 }
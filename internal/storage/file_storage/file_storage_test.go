@@ -0,0 +1,166 @@
+package filestorage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/storage/blob"
+)
+
+// newTestStorage создает хранилище в base/root и каталог base/outside с файлом secret,
+// а внутри хранилища - ссылки escape и link.txt, ведущие наружу
+func newTestStorage(t *testing.T) (*FileStorage, string) {
+	t.Helper()
+	base := t.TempDir()
+	outside := filepath.Join(base, "outside")
+	if err := os.Mkdir(outside, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := New(filepath.Join(base, "root"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+
+	if err := os.Symlink(outside, filepath.Join(f.Path, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(f.Path, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	return f, base
+}
+
+// assertNothingOutside проверяет, что вне корня хранилища не появилось и не пропало ни одного файла
+func assertNothingOutside(t *testing.T, base string) {
+	t.Helper()
+	var got []string
+	err := filepath.WalkDir(base, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(base, p)
+		if rel == "root" {
+			return filepath.SkipDir
+		}
+		if rel != "." {
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"outside", "outside/secret"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("files outside root = %v, want %v", got, want)
+	}
+	data, err := os.ReadFile(filepath.Join(base, "outside", "secret"))
+	if err != nil || string(data) != "secret" {
+		t.Fatalf("outside file changed: %q, %v", data, err)
+	}
+}
+
+// hostileKeys - ключи, которые не должны выводить за пределы хранилища
+func hostileKeys(base string) []struct{ name, key string } {
+	return []struct{ name, key string }{
+		{"empty", ""},
+		{"parent", "../outside/secret"},
+		{"nested parent", "a/../../outside/secret"},
+		{"trailing parent", "a/.."},
+		{"dot", "."},
+		{"absolute", filepath.Join(base, "outside", "secret")},
+		{"absolute slash", "/etc/passwd"},
+		{"backslash", `..\outside\secret`},
+		{"nul", "secret\x00.jpg"},
+		{"symlink dir", "escape/secret"},
+	}
+}
+
+func TestPutRejectsHostileKeys(t *testing.T) {
+	f, base := newTestStorage(t)
+	cases := append(hostileKeys(base), []struct{ name, key string }{
+		{"tmp dir", "tmp"},
+		{"tmp prefix", "tmp/evil.jpg"},
+		{"tmp prefix unclean", "./tmp//evil.jpg"},
+		{"symlink dir new file", "escape/evil.jpg"},
+	}...)
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := f.Put(context.Background(), tc.key, strings.NewReader("evil"), 4, "image/jpeg")
+			if err == nil {
+				t.Fatalf("Put(%q) succeeded", tc.key)
+			}
+			assertNothingOutside(t, base)
+		})
+	}
+}
+
+func TestGetRejectsHostileKeys(t *testing.T) {
+	f, base := newTestStorage(t)
+	cases := append(hostileKeys(base), struct{ name, key string }{"symlink file", "link.txt"})
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, _, err := f.Get(context.Background(), tc.key)
+			if err == nil {
+				r.Close()
+				t.Fatalf("Get(%q) succeeded", tc.key)
+			}
+			assertNothingOutside(t, base)
+		})
+	}
+}
+
+func TestDeleteRejectsHostileKeys(t *testing.T) {
+	f, base := newTestStorage(t)
+	cases := append(hostileKeys(base)[1:], struct{ name, key string }{"directory", "tmp"})
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := f.Delete(context.Background(), tc.key)
+			if err == nil {
+				t.Fatalf("Delete(%q) succeeded", tc.key)
+			}
+			assertNothingOutside(t, base)
+		})
+	}
+}
+
+func TestPutGetDelete(t *testing.T) {
+	f, base := newTestStorage(t)
+	ctx := context.Background()
+
+	err := f.Put(ctx, "originals/a.jpg", strings.NewReader("data"), 4, "image/jpeg")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	r, info, err := f.Get(ctx, "originals/a.jpg")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	r.Close()
+	if info.Key != "originals/a.jpg" || info.Size != 4 {
+		t.Fatalf("Get info = %+v", info)
+	}
+
+	err = f.Delete(ctx, "originals/a.jpg")
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	_, _, err = f.Get(ctx, "originals/a.jpg")
+	if !errors.Is(err, blob.ErrNotFound) {
+		t.Fatalf("Get after Delete = %v, want ErrNotFound", err)
+	}
+	assertNothingOutside(t, base)
+}
@@ -0,0 +1,160 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+)
+
+// IsJobProcessed сообщает, было ли задание с указанным ID уже успешно выполнено
+func (p *Postgres) IsJobProcessed(ctx context.Context, jobID string) (bool, error) {
+	var done bool
+	err := p.DB.GetContext(ctx, &done, `SELECT EXISTS(SELECT 1 FROM processed_jobs WHERE job_id = $1);`, jobID)
+	if err != nil {
+		return false, fmt.Errorf("[postgres] failed to check processed job: %w", err)
+	}
+	return done, nil
+}
+
+// ClaimImage переводит изображение в статус processing и выдает аренду lease.Owner на lease.TTL.
+// Захват возможен из статусов from или из processing с истекшей арендой (воркер упал);
+// пока аренда другого воркера действует, возвращается model.ErrImageLeased
+func (p *Postgres) ClaimImage(ctx context.Context, id int, from []model.Status, lease *model.Lease) error {
+	result, err := p.DB.ExecContext(ctx, `
+        UPDATE images
+        SET status = 'processing', error_message = '',
+            attempts = attempts + 1,
+            processing_owner = $2,
+            lease_expires_at = NOW() + $3 * INTERVAL '1 second',
+            updated_at = NOW()
+        WHERE id = $1
+          AND (status = ANY($4)
+               OR (status = 'processing' AND (lease_expires_at IS NULL OR lease_expires_at < NOW())))
+    `, id, lease.Owner, lease.TTL.Seconds(), statusList(from))
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to claim image: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to check rows affected: %w", err)
+	}
+	if rows > 0 {
+		return nil
+	}
+
+	var state struct {
+		Status model.Status `db:"status"`
+		Leased bool         `db:"leased"`
+	}
+	err = p.DB.GetContext(ctx, &state, `
+        SELECT status, COALESCE(lease_expires_at >= NOW(), false) AS leased
+        FROM images
+        WHERE id = $1;
+    `, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to check image lease: %w", err)
+	}
+	if state.Status == model.StatusProcessing && state.Leased {
+		return fmt.Errorf("[postgres] image %d: %w", id, model.ErrImageLeased)
+	}
+	return fmt.Errorf("[postgres] image %d: %w", id, model.ErrStatusConflict)
+}
+
+// RenewLease продлевает аренду изображения владельцем lease.Owner еще на lease.TTL;
+// если аренда уже перешла к другому воркеру, возвращается model.ErrLeaseLost
+func (p *Postgres) RenewLease(ctx context.Context, id int, lease *model.Lease) error {
+	result, err := p.DB.ExecContext(ctx, `
+        UPDATE images
+        SET lease_expires_at = NOW() + $3 * INTERVAL '1 second'
+        WHERE id = $1 AND status = 'processing' AND processing_owner = $2
+    `, id, lease.Owner, lease.TTL.Seconds())
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to renew lease: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to check rows affected: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("[postgres] image %d: %w", id, model.ErrLeaseLost)
+	}
+	return nil
+}
+
+// CompleteImage одной транзакцией сохраняет результат обработки: пути и статус processed,
+// набор вариантов и ID задания в processed_jobs. Запись обновляется только пока аренда
+// принадлежит lease.Owner, иначе возвращается model.ErrLeaseLost и ничего не сохраняется
+func (p *Postgres) CompleteImage(ctx context.Context, img *model.Image, variants []*model.ImageVariant, lease *model.Lease) error {
+	tx, err := p.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+        UPDATE images
+        SET processed_path = $1, thumbnail_path = $2, status = 'processed', error_message = '',
+            processed_at = NOW(), processing_owner = NULL, lease_expires_at = NULL,
+            updated_at = NOW()
+        WHERE id = $3 AND status = 'processing' AND processing_owner = $4
+    `, img.ProcessedPath, img.ThumbnailPath, img.ID, lease.Owner)
+	if err != nil {
+		return fmt.Errorf("[postgres] error completing image: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to check rows affected: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("[postgres] image %d: %w", img.ID, model.ErrLeaseLost)
+	}
+
+	err = replaceVariants(ctx, tx, img.ID, variants)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+	INSERT INTO processed_jobs (job_id, image_id, processing_owner)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (job_id) DO NOTHING;
+	`, lease.JobID, img.ID, lease.Owner)
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to record processed job: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to commit image: %w", err)
+	}
+	return nil
+}
+
+// ReleaseImage снимает аренду lease.Owner и возвращает изображение в статус enqueued,
+// не засчитывая прерванную попытку; чужая аренда не затрагивается
+func (p *Postgres) ReleaseImage(ctx context.Context, id int, lease *model.Lease) error {
+	result, err := p.DB.ExecContext(ctx, `
+        UPDATE images
+        SET status = 'enqueued', attempts = GREATEST(attempts - 1, 0),
+            processing_owner = NULL, lease_expires_at = NULL,
+            updated_at = NOW()
+        WHERE id = $1 AND status = 'processing' AND processing_owner = $2
+    `, id, lease.Owner)
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to release image: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to check rows affected: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("[postgres] image %d: %w", id, model.ErrLeaseLost)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package postgres
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+)
+
+// sortColumns - поля, по которым допускается сортировка списка; у каждого есть индекс (поле, id)
+var sortColumns = map[string]string{
+	"id":         "id",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+}
+
+// cursor - позиция страницы: значение поля сортировки и id последней записи предыдущей страницы
+type cursor struct {
+	Sort string    `json:"s"`
+	Time time.Time `json:"t,omitzero"`
+	ID   int       `json:"id"`
+}
+
+// ListImages возвращает страницу изображений по фильтрам с пагинацией по ключу (keyset):
+// следующая страница начинается строго после записи из курсора, без OFFSET
+func (p *Postgres) ListImages(ctx context.Context, q *model.ImageQuery) (*model.ImagePage, error) {
+	sort := q.Sort
+	if sort == "" {
+		sort = "id"
+	}
+	desc := strings.HasPrefix(sort, "-")
+	column, ok := sortColumns[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		return nil, fmt.Errorf("[postgres] %w: unknown sort %q", model.ErrInvalidQuery, q.Sort)
+	}
+
+	var where []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if len(q.Statuses) > 0 {
+		where = append(where, "status = ANY("+arg(statusList(q.Statuses))+")")
+	}
+	if q.CreatedFrom != nil {
+		where = append(where, "created_at >= "+arg(*q.CreatedFrom))
+	}
+	if q.CreatedTo != nil {
+		where = append(where, "created_at < "+arg(*q.CreatedTo))
+	}
+	if q.OwnerID != "" {
+		where = append(where, "owner_id = "+arg(q.OwnerID))
+	}
+	if q.Tag != "" {
+		where = append(where, "tags @> jsonb_build_array("+arg(q.Tag)+"::text)")
+	}
+	if q.Format != "" {
+		where = append(where, "format = "+arg(q.Format))
+	}
+
+	// общее число записей считается без учета курсора
+	var total int
+	err := p.DB.GetContext(ctx, &total, `SELECT COUNT(*) FROM images`+whereClause(where)+`;`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("[postgres] failed to count images: %w", err)
+	}
+
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil || c.Sort != sort {
+			return nil, fmt.Errorf("[postgres] %w: invalid cursor", model.ErrInvalidQuery)
+		}
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		if column == "id" {
+			where = append(where, "id "+op+" "+arg(c.ID))
+		} else {
+			where = append(where, "("+column+", id) "+op+" ("+arg(c.Time)+", "+arg(c.ID)+")")
+		}
+	}
+
+	order := " ASC"
+	if desc {
+		order = " DESC"
+	}
+	orderBy := "id" + order
+	if column != "id" {
+		orderBy = column + order + ", id" + order
+	}
+
+	var images []*model.Image
+	err = p.DB.SelectContext(ctx, &images, `
+		SELECT `+imageColumns+`
+		FROM images`+whereClause(where)+`
+		ORDER BY `+orderBy+`
+		LIMIT `+arg(q.Limit+1)+`;
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("[postgres] failed to list images: %w", err)
+	}
+
+	page := &model.ImagePage{Items: images, Total: total}
+	if len(images) > q.Limit {
+		page.Items = images[:q.Limit]
+		last := page.Items[len(page.Items)-1]
+		c := cursor{Sort: sort, ID: last.ID}
+		switch column {
+		case "created_at":
+			c.Time = last.CreatedAt
+		case "updated_at":
+			c.Time = last.UpdatedAt
+		}
+		page.NextCursor, err = encodeCursor(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+func whereClause(where []string) string {
+	if len(where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(where, " AND ")
+}
+
+func encodeCursor(c cursor) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("[postgres] failed to encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
+
+// CountImagesByStatus возвращает число изображений в каждом статусе
+func (p *Postgres) CountImagesByStatus(ctx context.Context) (map[string]int, error) {
+	var rows []struct {
+		Status string `db:"status"`
+		Count  int    `db:"count"`
+	}
+	err := p.DB.SelectContext(ctx, &rows, `SELECT status, COUNT(*) AS count FROM images GROUP BY status;`)
+	if err != nil {
+		return nil, fmt.Errorf("[postgres] failed to count images by status: %w", err)
+	}
+	counts := make(map[string]int, len(rows))
+	for _, r := range rows {
+		counts[r.Status] = r.Count
+	}
+	return counts, nil
+}
@@ -0,0 +1,125 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
+	"github.com/jmoiron/sqlx"
+)
+
+// EnqueueImage переводит изображение в статус enqueued, если текущий статус входит в from,
+// и в той же транзакции записывает задание в outbox
+func (p *Postgres) EnqueueImage(ctx context.Context, id int, from []model.Status, msg *model.OutboxMessage) error {
+	tx, err := p.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+        UPDATE images
+        SET status = $1, error_message = '', updated_at = NOW()
+        WHERE id = $2 AND status = ANY($3)
+    `, string(model.StatusEnqueued), id, statusList(from))
+	if err != nil {
+		return fmt.Errorf("[postgres] error updating image status: %w", err)
+	}
+	err = p.checkTransition(ctx, result, id)
+	if err != nil {
+		return err
+	}
+
+	err = insertOutbox(ctx, tx, msg)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to commit enqueue: %w", err)
+	}
+	return nil
+}
+
+// PublishOutbox забирает до limit неотправленных сообщений, передает их publish по порядку
+// и помечает отправленными. Строки блокируются FOR UPDATE SKIP LOCKED, поэтому несколько
+// экземпляров сервиса не отправят одно сообщение одновременно. Неудачная отправка
+// откладывает сообщение с экспоненциальной задержкой (не более минуты)
+func (p *Postgres) PublishOutbox(ctx context.Context, limit int, publish func(msg *model.OutboxMessage) error) (int, error) {
+	tx, err := p.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("[postgres] failed to begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var messages []*model.OutboxMessage
+	err = tx.SelectContext(ctx, &messages, `
+	SELECT id, key, payload, headers
+	FROM outbox
+	WHERE sent_at IS NULL AND next_attempt_at <= NOW()
+	ORDER BY id
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED;
+	`, limit)
+	if err != nil {
+		return 0, fmt.Errorf("[postgres] failed to select outbox: %w", err)
+	}
+
+	sent := 0
+	for _, msg := range messages {
+		pubErr := publish(msg)
+		if pubErr != nil {
+			p.log.WarnContext(ctx, "outbox message not sent", "message_id", msg.ID, logger.Err(pubErr))
+			_, err = tx.ExecContext(ctx, `
+			UPDATE outbox
+			SET attempts = attempts + 1,
+				last_error = $2,
+				next_attempt_at = NOW() + LEAST(INTERVAL '1 second' * power(2, attempts), INTERVAL '1 minute')
+			WHERE id = $1;
+			`, msg.ID, pubErr.Error())
+			if err != nil {
+				return sent, fmt.Errorf("[postgres] failed to postpone outbox message: %w", err)
+			}
+			// остальные сообщения ждут, чтобы не нарушить порядок заданий
+			break
+		}
+
+		_, err = tx.ExecContext(ctx, `UPDATE outbox SET sent_at = NOW() WHERE id = $1;`, msg.ID)
+		if err != nil {
+			return sent, fmt.Errorf("[postgres] failed to mark outbox message as sent: %w", err)
+		}
+		sent++
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return sent, fmt.Errorf("[postgres] failed to commit outbox: %w", err)
+	}
+	return sent, nil
+}
+
+// PurgeOutbox удаляет сообщения, отправленные раньше чем olderThan назад
+func (p *Postgres) PurgeOutbox(ctx context.Context, olderThan time.Duration) (int64, error) {
+	result, err := p.DB.ExecContext(ctx, `
+	DELETE FROM outbox
+	WHERE sent_at IS NOT NULL AND sent_at < NOW() - $1 * INTERVAL '1 second';
+	`, olderThan.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("[postgres] failed to purge outbox: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func insertOutbox(ctx context.Context, tx *sqlx.Tx, msg *model.OutboxMessage) error {
+	_, err := tx.ExecContext(ctx, `
+	INSERT INTO outbox (key, payload, headers)
+	VALUES ($1, $2, $3);
+	`, msg.Key, msg.Payload, msg.Headers)
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to write outbox: %w", err)
+	}
+	return nil
+}
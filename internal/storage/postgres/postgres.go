@@ -5,24 +5,40 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/Vladimirmoscow84/Image_processor/internal/model"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
+	"github.com/XSAM/otelsql"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/jmoiron/sqlx"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
 )
 
+// imageColumns - столбцы images в порядке полей model.Image
+const imageColumns = `id, original_path, original_name, content_hash, owner_id, tags, format,
+	processed_path, thumbnail_path, status, error_message, attempts, processed_at, options,
+	camera_make, camera_model, taken_at, width, height, orientation, created_at, updated_at`
+
 type Postgres struct {
-	DB *sqlx.DB
+	DB  *sqlx.DB
+	log *slog.Logger
 }
 
 // New - контсруткор соединения к БД
-func New(databaseURI string) (*Postgres, error) {
-	db, err := sqlx.Connect("pgx", databaseURI)
+func New(databaseURI string, log *slog.Logger) (*Postgres, error) {
+	log = logger.OrDefault(log).With("component", "postgres")
+
+	// каждый запрос оборачивается в спан трассировки
+	sqlDB, err := otelsql.Open("pgx", databaseURI,
+		otelsql.WithAttributes(semconv.DBSystemNamePostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true, DisableErrSkip: true}),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("[postgres] failed to connect to DB: %w ", err)
 	}
+	db := sqlx.NewDb(sqlDB, "pgx")
 	db.SetMaxOpenConns(10)
 	db.SetMaxIdleConns(5)
 	db.SetConnMaxLifetime(20 * time.Minute)
@@ -33,76 +49,133 @@ func New(databaseURI string) (*Postgres, error) {
 		return nil, fmt.Errorf("[postgres] ping failed: %w ", err)
 	}
 
-	log.Println("[postgres] successfull connect to DB")
+	log.Info("connected to DB")
 	return &Postgres{
-		DB: db,
+		DB:  db,
+		log: log,
 	}, nil
 }
 
+// Ping проверяет соединение с БД
+func (p *Postgres) Ping(ctx context.Context) error {
+	err := p.DB.PingContext(ctx)
+	if err != nil {
+		return fmt.Errorf("[postgres] ping failed: %w", err)
+	}
+	return nil
+}
+
 // Close закрывает соединение с БД
 func (p *Postgres) Close() error {
 	if p.DB != nil {
-		log.Println("[postgres] closing connection on DB")
+		p.log.Info("closing connection to DB")
 		return p.DB.Close()
 	}
 	return nil
 }
 
-var ErrNotFound = errors.New("image not found")
+var ErrNotFound = model.ErrImageNotFound
+
+// blobCollectBatch - сколько blob без ссылок собирается за один ReleaseBlob
+const blobCollectBatch = 100
+
+// AddImage добавляет новую запись в БД и возвращает ее id. Если изображение с тем же оригиналом,
+// владельцем и параметрами обработки уже есть, возвращается id существующей записи и created = false.
+// Для новой записи в той же транзакции в outbox пишется сообщение, построенное outbox по ее id
+func (p *Postgres) AddImage(ctx context.Context, image *model.Image, outbox func(id int) (*model.OutboxMessage, error)) (int, bool, error) {
+	tx, err := p.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("[postgres] failed to begin tx: %w", err)
+	}
+	defer tx.Rollback()
 
-// AddImage добавляет новую запись в БД и возвращает id фронту
-func (p *Postgres) AddImage(ctx context.Context, image *model.Image) (int, error) {
-	row := p.DB.QueryRowContext(ctx, `
+	row := tx.QueryRowContext(ctx, `
 	INSERT INTO images
-		(original_path, processed_path, thumbnail_path, status)
+		(original_path, original_name, content_hash, owner_id, tags, format, processed_path, thumbnail_path, status, options,
+		camera_make, camera_model, taken_at, width, height, orientation)
 	VALUES
-		($1,$2,$3,$4)
-		RETURNING id;
-	`, image.OriginalPath, image.ProcessedPath, image.ThumbnailPath, image.Status)
+		($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16)
+	ON CONFLICT (original_path, owner_id, options_hash) WHERE status <> 'deleting' DO UPDATE
+		SET updated_at = images.updated_at
+	RETURNING id, (xmax = 0) AS created;
+	`, image.OriginalPath, image.OriginalName, image.ContentHash, image.OwnerID, image.Tags, image.Format,
+		image.ProcessedPath, image.ThumbnailPath, string(image.Status), image.Options,
+		image.CameraMake, image.CameraModel, image.TakenAt, image.Width, image.Height, image.Orientation)
 
 	var id int
-	err := row.Scan(&id)
+	var created bool
+	err = row.Scan(&id, &created)
 	if err != nil {
-		log.Printf("[postgres] error adding image to DB: %v", err)
-		return 0, fmt.Errorf("[postgres] error adding image to DB: %w", err)
+		p.log.ErrorContext(ctx, "error adding image to DB", logger.Err(err))
+		return 0, false, fmt.Errorf("[postgres] error adding image to DB: %w", err)
+	}
+
+	if created && outbox != nil {
+		msg, err := outbox(id)
+		if err != nil {
+			return 0, false, err
+		}
+		err = insertOutbox(ctx, tx, msg)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, false, fmt.Errorf("[postgres] failed to commit image: %w", err)
 	}
 	image.ID = id
-	return id, nil
+	return id, created, nil
 }
 
-// GetImage возвращает запись из БД по id
-func (p *Postgres) GetImage(ctx context.Context, id int) (*model.Image, error) {
+// GetImageByOriginalPath возвращает изображение с указанным оригиналом, владельцем и параметрами обработки;
+// удаляемые изображения не находятся
+func (p *Postgres) GetImageByOriginalPath(ctx context.Context, originalPath, ownerID string, opts *model.ProcessingOptions) (*model.Image, error) {
 	var image model.Image
 	err := p.DB.GetContext(ctx, &image, `
-		SELECT 
-			id,
-			original_path,
-			processed_path,
-			thumbnail_path, 
-			status, 
-			created_at, 
-			updated_at
+		SELECT `+imageColumns+`
 		FROM images
-		WHERE id = $1;
-	`, id)
+		WHERE original_path = $1
+			AND owner_id = $2
+			AND options_hash = md5(COALESCE($3::jsonb::text, ''))
+			AND status <> 'deleting';
+	`, originalPath, ownerID, opts)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNotFound
 		}
-		log.Printf("[postgres] error getting image from DB: %v", err)
+		return nil, fmt.Errorf("[postgres] error getting image by original path: %w", err)
+	}
+	return &image, nil
+}
+
+// GetImage возвращает запись из БД по id; изображение вне scope не находится
+func (p *Postgres) GetImage(ctx context.Context, id int, scope model.OwnerScope) (*model.Image, error) {
+	var image model.Image
+	err := p.DB.GetContext(ctx, &image, `
+		SELECT `+imageColumns+`
+		FROM images
+		WHERE id = $1 AND ($2 OR owner_id = $3);
+	`, id, scope.All, scope.OwnerID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		p.log.ErrorContext(ctx, "error getting image from DB", logger.Err(err))
 		return nil, fmt.Errorf("[postgres] error getting image from DB: %w", err)
 	}
 	return &image, nil
 }
 
-// DeleteImage удаляет запись из БД
-func (p *Postgres) DeleteImage(ctx context.Context, id int) error {
+// DeleteImage удаляет запись из БД, если она входит в scope
+func (p *Postgres) DeleteImage(ctx context.Context, id int, scope model.OwnerScope) error {
 	result, err := p.DB.ExecContext(ctx, `
 	DELETE FROM images
-	WHERE id = $1;
-	`, id)
+	WHERE id = $1 AND ($2 OR owner_id = $3);
+	`, id, scope.All, scope.OwnerID)
 	if err != nil {
-		log.Printf("[postgres] error deleting image from DB: %v", err)
+		p.log.ErrorContext(ctx, "error deleting image from DB", logger.Err(err))
 		return fmt.Errorf("[postgres] error deleting image from DB: %w", err)
 	}
 	rows, err := result.RowsAffected()
@@ -115,28 +188,199 @@ func (p *Postgres) DeleteImage(ctx context.Context, id int) error {
 	return nil
 }
 
-func (p *Postgres) UpdateImage(ctx context.Context, img *model.Image) error {
+// UpdateImageStatus переводит изображение в статус to, если текущий статус входит в from.
+// Переход в processing увеличивает счетчик попыток, в processed - фиксирует время обработки,
+// выход из processing снимает аренду воркера
+func (p *Postgres) UpdateImageStatus(ctx context.Context, id int, from []model.Status, to model.Status, errMsg string) error {
+	result, err := p.DB.ExecContext(ctx, `
+        UPDATE images
+        SET status = $1, error_message = $2,
+            attempts = attempts + CASE WHEN $1 = 'processing' THEN 1 ELSE 0 END,
+            processed_at = CASE WHEN $1 = 'processed' THEN NOW() ELSE processed_at END,
+            processing_owner = CASE WHEN $1 = 'processing' THEN processing_owner END,
+            lease_expires_at = CASE WHEN $1 = 'processing' THEN lease_expires_at END,
+            updated_at = NOW()
+        WHERE id = $3 AND status = ANY($4)
+    `, string(to), errMsg, id, statusList(from))
+	if err != nil {
+		p.log.ErrorContext(ctx, "error updating image status", logger.Err(err))
+		return fmt.Errorf("[postgres] error updating image status: %w", err)
+	}
+	return p.checkTransition(ctx, result, id)
+}
+
+// checkTransition отличает отсутствующую запись от запрещенного перехода статуса
+func (p *Postgres) checkTransition(ctx context.Context, result sql.Result, id int) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to check rows affected: %w", err)
+	}
+	if rows > 0 {
+		return nil
+	}
+
+	var exists bool
+	err = p.DB.GetContext(ctx, &exists, `SELECT EXISTS(SELECT 1 FROM images WHERE id = $1);`, id)
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to check image existence: %w", err)
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return fmt.Errorf("[postgres] image %d: %w", id, model.ErrStatusConflict)
+}
+
+func statusList(statuses []model.Status) []string {
+	list := make([]string, len(statuses))
+	for i, st := range statuses {
+		list[i] = string(st)
+	}
+	return list
+}
+
+// replaceVariants заменяет набор вариантов изображения в транзакции tx
+func replaceVariants(ctx context.Context, tx *sqlx.Tx, imageID int, variants []*model.ImageVariant) error {
+	_, err := tx.ExecContext(ctx, `
+	DELETE FROM image_variants
+	WHERE image_id = $1;
+	`, imageID)
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to clear image variants: %w", err)
+	}
+
+	for _, v := range variants {
+		_, err = tx.ExecContext(ctx, `
+		INSERT INTO image_variants
+			(image_id, name, path, width, height)
+		VALUES
+			($1,$2,$3,$4,$5);
+		`, imageID, v.Name, v.Path, v.Width, v.Height)
+		if err != nil {
+			return fmt.Errorf("[postgres] error adding image variant to DB: %w", err)
+		}
+		v.ImageID = imageID
+	}
+
+	return nil
+}
+
+// GetImageVariants возвращает все сохраненные варианты изображения
+func (p *Postgres) GetImageVariants(ctx context.Context, imageID int) ([]*model.ImageVariant, error) {
+	var variants []*model.ImageVariant
+	err := p.DB.SelectContext(ctx, &variants, `
+        SELECT image_id, name, path, width, height, created_at
+        FROM image_variants
+        WHERE image_id = $1
+        ORDER BY name ASC;
+    `, imageID)
+	if err != nil {
+		return nil, fmt.Errorf("[postgres] failed to get image variants: %w", err)
+	}
+	return variants, nil
+}
+
+// AcquireBlob добавляет ссылку на blob с содержимым hash, создавая запись при первой ссылке
+func (p *Postgres) AcquireBlob(ctx context.Context, hash, path string, size int64) error {
 	_, err := p.DB.ExecContext(ctx, `
-        UPDATE images 
-        SET processed_path=$1, thumbnail_path=$2, status=$3, updated_at = NOW() 
-        WHERE id=$4
-    `,
-		img.ProcessedPath,
-		img.ThumbnailPath,
-		img.Status,
-		img.ID,
-	)
-	return err
+	INSERT INTO blobs
+		(hash, path, size, ref_count)
+	VALUES
+		($1,$2,$3,1)
+	ON CONFLICT (hash) DO UPDATE
+		SET ref_count = blobs.ref_count + 1;
+	`, hash, path, size)
+	if err != nil {
+		p.log.ErrorContext(ctx, "error acquiring blob", logger.Err(err))
+		return fmt.Errorf("[postgres] error acquiring blob: %w", err)
+	}
+	return nil
+}
+
+// ReleaseBlob снимает ссылку на blob. При снятии последней ссылки remove вызывается
+// под блокировкой записи, поэтому параллельный AcquireBlob дождется удаления файла.
+// Заодно собираются другие blob без ссылок (см. collectBlobs)
+func (p *Postgres) ReleaseBlob(ctx context.Context, hash string, remove func(path string) error) error {
+	tx, err := p.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var blob struct {
+		Path     string `db:"path"`
+		RefCount int    `db:"ref_count"`
+	}
+	err = tx.GetContext(ctx, &blob, `
+	UPDATE blobs
+	SET ref_count = ref_count - 1
+	WHERE hash = $1
+	RETURNING path, ref_count;
+	`, hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("[postgres] error releasing blob: %w", err)
+	}
+
+	if blob.RefCount <= 0 {
+		err = remove(blob.Path)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM blobs WHERE hash = $1;`, hash)
+		if err != nil {
+			return fmt.Errorf("[postgres] error deleting blob: %w", err)
+		}
+	}
+
+	err = p.collectBlobs(ctx, tx, remove)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("[postgres] failed to commit blob release: %w", err)
+	}
+	return nil
 }
-func (p *Postgres) GetAllImages(ctx context.Context) ([]*model.Image, error) {
-	var images []*model.Image
-	err := p.DB.SelectContext(ctx, &images, `
-        SELECT id, original_path, processed_path, thumbnail_path, status, created_at, updated_at
-        FROM images
-        ORDER BY id ASC;
-    `)
+
+// collectBlobs удаляет до blobCollectBatch записей blob без ссылок вместе с файлами. Такие записи
+// не снимет ни один ReleaseBlob - их оставляет, например, удаление дубликатов в миграции 000009.
+// Записи, заблокированные другими транзакциями, пропускаются; файл, который не удалось удалить,
+// остается до следующей сборки
+func (p *Postgres) collectBlobs(ctx context.Context, tx *sqlx.Tx, remove func(path string) error) error {
+	var orphans []struct {
+		Hash string `db:"hash"`
+		Path string `db:"path"`
+	}
+	err := tx.SelectContext(ctx, &orphans, `
+	SELECT hash, path
+	FROM blobs
+	WHERE ref_count <= 0
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED;
+	`, blobCollectBatch)
 	if err != nil {
-		return nil, fmt.Errorf("[postgres] failed to get all images: %w", err)
+		return fmt.Errorf("[postgres] error selecting unreferenced blobs: %w", err)
 	}
-	return images, nil
+
+	collected := 0
+	for _, b := range orphans {
+		err = remove(b.Path)
+		if err != nil {
+			p.log.WarnContext(ctx, "failed to remove unreferenced blob", "hash", b.Hash, logger.Err(err))
+			continue
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM blobs WHERE hash = $1;`, b.Hash)
+		if err != nil {
+			return fmt.Errorf("[postgres] error deleting unreferenced blob: %w", err)
+		}
+		collected++
+	}
+	if collected > 0 {
+		p.log.InfoContext(ctx, "unreferenced blobs collected", "blobs", collected)
+	}
+	return nil
 }
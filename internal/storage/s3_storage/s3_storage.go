@@ -0,0 +1,178 @@
+package s3storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/storage/blob"
+	"github.com/Vladimirmoscow84/Image_processor/internal/tracing"
+	"github.com/Vladimirmoscow84/Image_processor/pkg/logger"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	UseSSL    bool
+}
+
+type S3Storage struct {
+	client *minio.Client
+	bucket string
+	log    *slog.Logger
+}
+
+// New - конструктор S3-совместимого хранилища; бакет создается, если его нет
+func New(ctx context.Context, cfg *Config, log *slog.Logger) (*S3Storage, error) {
+	log = logger.OrDefault(log).With("component", "s3storage")
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, fmt.Errorf("[s3Storage] endpoint and bucket are required")
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("[s3Storage] failed to create client: %w", err)
+	}
+
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("[s3Storage] failed to check bucket: %w", err)
+	}
+	if !exists {
+		err = client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region})
+		if err != nil {
+			return nil, fmt.Errorf("[s3Storage] failed to create bucket: %w", err)
+		}
+		log.InfoContext(ctx, "bucket created", "bucket", cfg.Bucket)
+	}
+
+	return &S3Storage{
+		client: client,
+		bucket: cfg.Bucket,
+		log:    log,
+	}, nil
+}
+
+// healthKey - объект, который CheckWritable записывает и сразу удаляет
+const healthKey = "tmp/healthcheck"
+
+// CheckWritable записывает и удаляет пробный объект в бакете
+func (s *S3Storage) CheckWritable(ctx context.Context) error {
+	_, err := s.client.PutObject(ctx, s.bucket, healthKey, strings.NewReader("ok"), 2, minio.PutObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("[s3Storage] bucket is not writable: %w", err)
+	}
+	err = s.client.RemoveObject(ctx, s.bucket, healthKey, minio.RemoveObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("[s3Storage] failed to remove probe object: %w", err)
+	}
+	return nil
+}
+
+// Put загружает объект в бакет
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (err error) {
+	ctx, span := blob.StartSpan(ctx, "s3", "Put", key)
+	defer func() { tracing.End(span, err) }()
+
+	key, err = blob.CleanKey(key)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(ctx, s.bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return fmt.Errorf("[s3Storage] failed to put object: %w", err)
+	}
+	return nil
+}
+
+// Get открывает объект на чтение; объект поддерживает Seek для выдачи диапазонов
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadSeekCloser, *blob.ObjectInfo, error) {
+	key, err := blob.CleanKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, nil, s.wrapError("get object", err)
+	}
+	st, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, nil, s.wrapError("get object", err)
+	}
+	return obj, objectInfo(st), nil
+}
+
+// Stat возвращает метаданные объекта
+func (s *S3Storage) Stat(ctx context.Context, key string) (*blob.ObjectInfo, error) {
+	key, err := blob.CleanKey(key)
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s.wrapError("stat object", err)
+	}
+	return objectInfo(st), nil
+}
+
+// Delete удаляет объект; отсутствие объекта ошибкой не считается
+func (s *S3Storage) Delete(ctx context.Context, key string) (err error) {
+	if key == "" {
+		return nil
+	}
+	ctx, span := blob.StartSpan(ctx, "s3", "Delete", key)
+	defer func() { tracing.End(span, err) }()
+
+	key, err = blob.CleanKey(key)
+	if err != nil {
+		return err
+	}
+	err = s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("[s3Storage] failed to delete object: %w", err)
+	}
+	s.log.DebugContext(ctx, "object deleted", "key", key)
+	return nil
+}
+
+// List возвращает все объекты с ключами, начинающимися с prefix
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]blob.ObjectInfo, error) {
+	var objects []blob.ObjectInfo
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("[s3Storage] failed to list objects: %w", obj.Err)
+		}
+		objects = append(objects, *objectInfo(obj))
+	}
+	return objects, nil
+}
+
+func (s *S3Storage) wrapError(op string, err error) error {
+	if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+		return blob.ErrNotFound
+	}
+	return fmt.Errorf("[s3Storage] failed to %s: %w", op, err)
+}
+
+func objectInfo(st minio.ObjectInfo) *blob.ObjectInfo {
+	return &blob.ObjectInfo{
+		Key:         st.Key,
+		Size:        st.Size,
+		ContentType: st.ContentType,
+		ETag:        st.ETag,
+		ModTime:     st.LastModified,
+	}
+}
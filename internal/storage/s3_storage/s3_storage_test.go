@@ -0,0 +1,131 @@
+package s3storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Vladimirmoscow84/Image_processor/internal/storage/blob"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+// newTestStorage поднимает S3 в памяти и создает хранилище; бакет создает New
+func newTestStorage(t *testing.T) *S3Storage {
+	t.Helper()
+	srv := httptest.NewServer(gofakes3.New(s3mem.New()).Server())
+	t.Cleanup(srv.Close)
+
+	s, err := New(context.Background(), &Config{
+		Endpoint:  strings.TrimPrefix(srv.URL, "http://"),
+		AccessKey: "test",
+		SecretKey: "test",
+		Bucket:    "images",
+		Region:    "us-east-1",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestPutGetDelete(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	const key = "processed/ab/cd/abcd-1.jpg"
+
+	err := s.Put(ctx, key, strings.NewReader("jpeg data"), 9, "image/jpeg")
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	r, info, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(data) != "jpeg data" {
+		t.Fatalf("Get data = %q", data)
+	}
+	if info.Key != key || info.Size != 9 || info.ContentType != "image/jpeg" || info.ETag == "" {
+		t.Fatalf("Get info = %+v", info)
+	}
+
+	st, err := s.Stat(ctx, key)
+	if err != nil || st.Size != 9 {
+		t.Fatalf("Stat = %+v, %v", st, err)
+	}
+
+	objects, err := s.List(ctx, "processed/")
+	if err != nil || len(objects) != 1 || objects[0].Key != key {
+		t.Fatalf("List = %+v, %v", objects, err)
+	}
+
+	err = s.Delete(ctx, key)
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	_, _, err = s.Get(ctx, key)
+	if !errors.Is(err, blob.ErrNotFound) {
+		t.Fatalf("Get after Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	_, _, err := s.Get(ctx, "processed/missing.jpg")
+	if !errors.Is(err, blob.ErrNotFound) {
+		t.Fatalf("Get = %v, want ErrNotFound", err)
+	}
+	_, err = s.Stat(ctx, "processed/missing.jpg")
+	if !errors.Is(err, blob.ErrNotFound) {
+		t.Fatalf("Stat = %v, want ErrNotFound", err)
+	}
+	err = s.Delete(ctx, "processed/missing.jpg")
+	if err != nil {
+		t.Fatalf("Delete of missing object = %v, want nil", err)
+	}
+}
+
+func TestInvalidKeys(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	for _, key := range []string{"../escape.jpg", "/abs.jpg", `a\b.jpg`, "a\x00.jpg"} {
+		err := s.Put(ctx, key, strings.NewReader("x"), 1, "image/jpeg")
+		if !errors.Is(err, blob.ErrInvalidKey) {
+			t.Fatalf("Put(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		_, _, err = s.Get(ctx, key)
+		if !errors.Is(err, blob.ErrInvalidKey) {
+			t.Fatalf("Get(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		err = s.Delete(ctx, key)
+		if !errors.Is(err, blob.ErrInvalidKey) {
+			t.Fatalf("Delete(%q) = %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	err := s.CheckWritable(ctx)
+	if err != nil {
+		t.Fatalf("CheckWritable: %v", err)
+	}
+	_, err = s.Stat(ctx, healthKey)
+	if !errors.Is(err, blob.ErrNotFound) {
+		t.Fatalf("probe object left in bucket: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Форматы вывода
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Config - параметры логгера
+type Config struct {
+	// Level - debug, info, warn или error (по умолчанию info)
+	Level string
+	// Format - text или json (по умолчанию text)
+	Format string
+}
+
+// New создает логгер, который дополняет каждую запись идентификаторами из контекста:
+// request_id, job_id, image_id и trace_id
+func New(w io.Writer, cfg Config) (*slog.Logger, error) {
+	var level slog.Level
+	if cfg.Level != "" {
+		err := level.UnmarshalText([]byte(cfg.Level))
+		if err != nil {
+			return nil, fmt.Errorf("[logger] invalid level %q: %w", cfg.Level, err)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var h slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "", FormatText:
+		h = slog.NewTextHandler(w, opts)
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("[logger] unknown format %q", cfg.Format)
+	}
+	return slog.New(contextHandler{h}), nil
+}
+
+// OrDefault возвращает l или глобальный логгер, если l не задан
+func OrDefault(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return slog.Default()
+	}
+	return l
+}
+
+// Err - атрибут с ошибкой
+func Err(err error) slog.Attr {
+	return slog.Any("error", err)
+}
+
+type ctxKey int
+
+const (
+	requestIDKey ctxKey = iota
+	jobIDKey
+	imageIDKey
+)
+
+// WithRequestID сохраняет ID HTTP-запроса в контексте
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID возвращает ID HTTP-запроса из контекста или пустую строку
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithJobID сохраняет ID задания в контексте
+func WithJobID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, jobIDKey, id)
+}
+
+// WithImageID сохраняет ID изображения в контексте
+func WithImageID(ctx context.Context, id int) context.Context {
+	return context.WithValue(ctx, imageIDKey, id)
+}
+
+// contextHandler добавляет к записи идентификаторы, сохраненные в контексте
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if id, ok := ctx.Value(jobIDKey).(string); ok && id != "" {
+		r.AddAttrs(slog.String("job_id", id))
+	}
+	if id, ok := ctx.Value(imageIDKey).(int); ok {
+		r.AddAttrs(slog.Int("image_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -1,7 +1,28 @@
-function thumbURL(p) {
-    if (!p) return null;
-    const normalized = p.startsWith("/") ? p : "/" + p;
-    return encodeURI(normalized);
+function thumbURL(id) {
+    return `/image/${id}?variant=thumb`;
+}
+
+// API-ключ хранится в браузере и передается в заголовке X-API-Key каждого запроса
+function apiKey() {
+    return localStorage.getItem("apiKey") || "";
+}
+
+function saveApiKey() {
+    localStorage.setItem("apiKey", document.getElementById("apiKeyInput").value.trim());
+    loadImages();
+}
+
+function apiFetch(url, options = {}) {
+    const headers = new Headers(options.headers || {});
+    if (apiKey()) headers.set("X-API-Key", apiKey());
+    return fetch(url, { ...options, headers });
+}
+
+// <img> не умеет передавать заголовки, поэтому миниатюра загружается через fetch
+async function loadThumb(imgEl, id) {
+    const resp = await apiFetch(thumbURL(id));
+    if (!resp.ok) return;
+    imgEl.src = URL.createObjectURL(await resp.blob());
 }
 
 async function uploadImage() {
@@ -14,22 +35,41 @@ async function uploadImage() {
     const formData = new FormData();
     formData.append("image", file);
 
-    const resp = await fetch("/upload", { method: "POST", body: formData });
+    const resp = await apiFetch("/upload", { method: "POST", body: formData });
     const data = await resp.json();
+    if (!resp.ok) {
+        alert("Ошибка загрузки: " + data.error);
+        return;
+    }
 
     alert("Файл отправлен на обработку!");
     loadImages(); 
 }
 
-async function loadImages() {
+let nextCursor = "";
+
+async function loadImages(more = false) {
     const listBox = document.getElementById("imageList");
-    listBox.innerHTML = "";
+    if (!more) {
+        listBox.innerHTML = "";
+        nextCursor = "";
+    }
 
-    const response = await fetch("/images");
+    const params = new URLSearchParams({ limit: 50, sort: "-created_at" });
+    if (more && nextCursor) params.set("cursor", nextCursor);
+
+    const response = await apiFetch(`/images?${params}`);
+    if (response.status === 401) {
+        alert("Введите API-ключ");
+        return;
+    }
     if (!response.ok) return;
 
-    const images = await response.json();
-    images.forEach(img => renderImageCard(img));
+    const page = await response.json();
+    page.items.forEach(img => renderImageCard(img));
+
+    nextCursor = page.next_cursor || "";
+    document.getElementById("loadMore").style.display = nextCursor ? "" : "none";
 }
 
 function renderImageCard(img) {
@@ -43,18 +83,27 @@ function renderImageCard(img) {
         <p><b>Status:</b> ${img.status}</p>
         
         ${img.status === "processed" && img.thumbnailPath
-            ? `<img src="${thumbURL(img.thumbnailPath)}" alt="thumb">`
-            : `<p>В обработке...</p>`
+            ? `<img alt="thumb">`
+            : img.status === "failed"
+                ? `<p class="error-message"></p>`
+                : `<p>В обработке...</p>`
         }
 
         <button onclick="deleteImage(${img.id})">Удалить</button>
     `;
 
+    const thumb = card.querySelector("img");
+    if (thumb) loadThumb(thumb, img.id);
+
+    // текст ошибки приходит с сервера и может содержать разметку - вставляем только как текст
+    const errorBox = card.querySelector(".error-message");
+    if (errorBox) errorBox.textContent = `Ошибка обработки: ${img.errorMessage || ""}`;
+
     list.appendChild(card);
 }
 
 async function deleteImage(id) {
-    const resp = await fetch(`/image/${id}`, { method: "DELETE" });
+    const resp = await apiFetch(`/image/${id}`, { method: "DELETE" });
     if (resp.ok) {
         alert("Удалено");
         loadImages();
@@ -62,4 +111,7 @@ async function deleteImage(id) {
 }
 
 
-window.addEventListener("load", loadImages);
+window.addEventListener("load", () => {
+    document.getElementById("apiKeyInput").value = apiKey();
+    loadImages();
+});